@@ -0,0 +1,228 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log/level"
+	"github.com/gosnmp/gosnmp"
+
+	"github.com/shatteredsilicon/snmp_exporter/config"
+)
+
+var (
+	authFallbackTTL        = kingpin.Flag("snmp.auth-fallback-ttl", "How long the first working auth of a list of auths is remembered for a target.").Default("10m").Duration()
+	authFallbackFailureTTL = kingpin.Flag("snmp.auth-fallback-failure-ttl", "How long it is remembered that no auth of a list of auths works for a target.").Default("1m").Duration()
+)
+
+// sysObjectID is mandatory for SNMP agents, so a get of it tells whether
+// the target accepts an auth.
+const sysObjectIDOid = "1.3.6.1.2.1.1.2.0"
+
+type NamedAuth struct {
+	*config.Auth
+	name string
+}
+
+func NewNamedAuth(name string, auth *config.Auth) *NamedAuth {
+	return &NamedAuth{
+		Auth: auth,
+		name: name,
+	}
+}
+
+type authSelection struct {
+	name    string
+	expires time.Time
+	// Whether no auth worked, and name is the first one.
+	failed bool
+}
+
+// authCache remembers which auth of a list of auths works for a target.
+type authCache struct {
+	mu      sync.Mutex
+	entries map[string]authSelection
+}
+
+func newAuthCache() *authCache {
+	return &authCache{entries: make(map[string]authSelection)}
+}
+
+var selectedAuths = newAuthCache()
+
+func authCacheKey(target string, auths []*NamedAuth) string {
+	names := make([]string, len(auths))
+	for i, auth := range auths {
+		names[i] = auth.name
+	}
+	return target + "\x00" + strings.Join(names, ",")
+}
+
+func (c *authCache) get(key string, now time.Time) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, s := range c.entries {
+		if now.After(s.expires) {
+			delete(c.entries, k)
+		}
+	}
+	s, ok := c.entries[key]
+	return s.name, ok
+}
+
+func (c *authCache) put(key, name string, expires time.Time, failed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = authSelection{name: name, expires: expires, failed: failed}
+}
+
+// delete forgets the auth selected for the key. That no auth works is kept
+// until it expires, so that unreachable targets aren't probed with every
+// auth on each scrape.
+func (c *authCache) delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.entries[key].failed {
+		delete(c.entries, key)
+	}
+}
+
+func (c *authCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]authSelection)
+}
+
+// ResetAuthCache forgets which auths work for which targets. It must be
+// called when the configuration is reloaded.
+func ResetAuthCache() {
+	selectedAuths.reset()
+}
+
+// snmpEngine is the SNMPv3 engine of a target.
+type snmpEngine struct {
+	id    string
+	boots uint32
+	time  uint32
+}
+
+// probeAuth gets sysObjectID from the target with the auth. SNMPv1 and v2c
+// agents don't respond to unknown communities, and SNMPv3 agents respond
+// with a report if they don't accept the user, so an error means the target
+// doesn't accept the auth. The session is taken from and handed back to the
+// session pool like those of scrapes, so the SNMPv3 engine it discovered is
+// copied before it is handed back.
+func probeAuth(ctx context.Context, target string, auth *config.Auth, params config.WalkParams, metrics Metrics) (*gosnmp.SnmpPacket, snmpEngine, error) {
+	key := newSessionKey(target, auth, params)
+	snmp, err := openSession(ctx, key, target, auth, params, &ScrapeResults{}, metrics)
+	if err != nil {
+		return nil, snmpEngine{}, err
+	}
+	packet, err := snmp.Get([]string{sysObjectIDOid})
+	var engine snmpEngine
+	if snmp.Version == gosnmp.Version3 {
+		// Engine discovery succeeds even if the user is rejected.
+		if usm, ok := snmp.SecurityParameters.(*gosnmp.UsmSecurityParameters); ok {
+			engine = snmpEngine{
+				id:    usm.AuthoritativeEngineID,
+				boots: usm.AuthoritativeEngineBoots,
+				time:  usm.AuthoritativeEngineTime,
+			}
+		}
+	}
+	releaseSession(key, snmp, err == nil)
+	return packet, engine, err
+}
+
+// AuthCheckResult is the outcome of a get of sysObjectID from a target with
+// an auth.
+type AuthCheckResult struct {
+	Auth        string `json:"auth"`
+	Success     bool   `json:"success"`
+	Reason      string `json:"reason,omitempty"`
+	Error       string `json:"error,omitempty"`
+	SysObjectID string `json:"sys_object_id,omitempty"`
+	// The SNMPv3 engine of the target, with the ID hex encoded.
+	EngineID       string  `json:"engine_id,omitempty"`
+	EngineBoots    uint32  `json:"engine_boots,omitempty"`
+	EngineTime     uint32  `json:"engine_time,omitempty"`
+	LatencySeconds float64 `json:"latency_seconds"`
+}
+
+// CheckAuth checks whether the target accepts the auth.
+func CheckAuth(ctx context.Context, target string, auth *NamedAuth, params config.WalkParams, metrics Metrics) AuthCheckResult {
+	result := AuthCheckResult{Auth: auth.name}
+	start := time.Now()
+	packet, engine, err := probeAuth(ctx, target, auth.Auth, params, metrics)
+	result.LatencySeconds = time.Since(start).Seconds()
+	if engine.id != "" {
+		result.EngineID = hex.EncodeToString([]byte(engine.id))
+		result.EngineBoots = engine.boots
+		result.EngineTime = engine.time
+	}
+	if err != nil {
+		result.Reason = errorReason(err)
+		result.Error = err.Error()
+		return result
+	}
+	result.Success = true
+	if len(packet.Variables) > 0 && packet.Variables[0].Type == gosnmp.ObjectIdentifier {
+		result.SysObjectID = strings.TrimPrefix(packet.Variables[0].Value.(string), ".")
+	}
+	return result
+}
+
+// selectAuth returns the auth to scrape the target with. Of a list of auths
+// the first that works is returned, or the first one if none works.
+// Both are remembered for the target, the latter for a shorter time.
+// Replayed targets have no agent to probe, and get the first one.
+func (c Collector) selectAuth() *NamedAuth {
+	if len(c.auths) == 1 || strings.HasPrefix(c.target, ReplayScheme) {
+		return c.auths[0]
+	}
+	key := authCacheKey(c.target, c.auths)
+	if name, ok := selectedAuths.get(key, time.Now()); ok {
+		for _, auth := range c.auths {
+			if auth.name == name {
+				return auth
+			}
+		}
+	}
+	params := config.DefaultWalkParams
+	if len(c.modules) > 0 {
+		params = c.modules[0].WalkParams
+	}
+	for _, auth := range c.auths {
+		_, _, err := probeAuth(c.ctx, c.target, auth.Auth, params, c.metrics)
+		if err == nil {
+			level.Debug(c.logger).Log("msg", "Selected auth for target", "auth", auth.name)
+			selectedAuths.put(key, auth.name, time.Now().Add(*authFallbackTTL), false)
+			return auth
+		}
+		level.Debug(c.logger).Log("msg", "Auth doesn't work for target", "auth", auth.name, "reason", errorReason(err), "err", err)
+		if c.ctx.Err() != nil {
+			level.Info(c.logger).Log("msg", "Scrape deadline passed while probing auths, scraping with the first", "auth", c.auths[0].name)
+			return c.auths[0]
+		}
+	}
+	level.Info(c.logger).Log("msg", "No auth works for target, scraping with the first", "auth", c.auths[0].name)
+	selectedAuths.put(key, c.auths[0].name, time.Now().Add(*authFallbackFailureTTL), true)
+	return c.auths[0]
+}
@@ -0,0 +1,271 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/shatteredsilicon/snmp_exporter/config"
+)
+
+type cachedScrape struct {
+	samples []prometheus.Metric
+	expires time.Time
+}
+
+// scrapeCache keeps the samples of the last successful scrape of modules
+// with a min_interval, so that fragile devices aren't walked too often.
+type scrapeCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedScrape
+}
+
+func newScrapeCache() *scrapeCache {
+	return &scrapeCache{entries: make(map[string]cachedScrape)}
+}
+
+var (
+	scrapes = newScrapeCache()
+	// In-flight scrapes of the same target, auth and module are done once
+	// and their samples shared by all callers.
+	scrapeGroup = newSharedScrapes()
+)
+
+// scrapeKey identifies scrapes with the same result. The walk parameters
+// are part of it, as targets of the inventory can override those of the
+// module.
+func scrapeKey(target, authName, module string, params config.WalkParams) string {
+	retries := 0
+	if params.Retries != nil {
+		retries = *params.Retries
+	}
+	return fmt.Sprintf("%s\x00%s\x00%s\x00%d,%d,%s,%t,%t,%d,%t,%s", target, authName, module,
+		params.MaxRepetitions, retries, params.Timeout,
+		config.Enabled(params.UseUnconnectedUDPSocket), config.Enabled(params.AllowNonIncreasingOIDs),
+		params.WalkConcurrency, config.Enabled(params.AdaptiveMaxRepetitions), params.AdaptiveMaxRepetitionsTTL)
+}
+
+// get returns the cached samples for key if they haven't expired yet.
+func (s *scrapeCache) get(key string, now time.Time) ([]prometheus.Metric, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !now.Before(e.expires) {
+		delete(s.entries, key)
+		return nil, false
+	}
+	return e.samples, true
+}
+
+// put caches samples for key until now plus interval.
+func (s *scrapeCache) put(key string, samples []prometheus.Metric, now time.Time, interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, e := range s.entries {
+		if !now.Before(e.expires) {
+			delete(s.entries, k)
+		}
+	}
+	s.entries[key] = cachedScrape{samples: samples, expires: now.Add(interval)}
+}
+
+// reset drops all cached samples.
+func (s *scrapeCache) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = make(map[string]cachedScrape)
+}
+
+// ResetScrapeCache drops all cached scrape results. It must be called when
+// the configuration is reloaded, so that no result of an old module is served.
+func ResetScrapeCache() {
+	scrapes.reset()
+}
+
+// sharedContext is the context of a scrape shared by several requests. It
+// isn't derived from any of them: its deadline is the latest of theirs, and
+// it's only cancelled once all of them are gone.
+type sharedContext struct {
+	mu       sync.Mutex
+	done     chan struct{}
+	err      error
+	deadline time.Time
+	// Whether all requests have a deadline.
+	bounded bool
+	timer   *time.Timer
+	waiters int
+}
+
+func newSharedContext() *sharedContext {
+	return &sharedContext{done: make(chan struct{}), bounded: true}
+}
+
+func (c *sharedContext) Deadline() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.bounded || c.deadline.IsZero() {
+		return time.Time{}, false
+	}
+	return c.deadline, true
+}
+
+func (c *sharedContext) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *sharedContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *sharedContext) Value(key interface{}) interface{} {
+	return nil
+}
+
+// join adds a request waiting for the scrape, extending the deadline to
+// that of the request.
+func (c *sharedContext) join(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.waiters++
+	if c.err != nil || !c.bounded {
+		return
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		c.bounded = false
+		if c.timer != nil {
+			c.timer.Stop()
+		}
+		return
+	}
+	if !deadline.After(c.deadline) {
+		return
+	}
+	c.deadline = deadline
+	if c.timer == nil {
+		c.timer = time.AfterFunc(time.Until(deadline), c.expire)
+	} else {
+		c.timer.Reset(time.Until(deadline))
+	}
+}
+
+func (c *sharedContext) expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.bounded || time.Now().Before(c.deadline) {
+		// The deadline was extended meanwhile.
+		return
+	}
+	c.cancelLocked(context.DeadlineExceeded)
+}
+
+// leave removes a request that stopped waiting, and cancels the scrape if
+// it was the last one.
+func (c *sharedContext) leave() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.waiters--
+	if c.waiters > 0 {
+		return
+	}
+	if c.bounded && !time.Now().Before(c.deadline) {
+		c.cancelLocked(context.DeadlineExceeded)
+	} else {
+		c.cancelLocked(context.Canceled)
+	}
+}
+
+func (c *sharedContext) cancel() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cancelLocked(context.Canceled)
+}
+
+func (c *sharedContext) cancelLocked(err error) {
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.done)
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+}
+
+// sharedScrape is a scrape in flight.
+type sharedScrape struct {
+	ctx     *sharedContext
+	done    chan struct{}
+	samples []prometheus.Metric
+}
+
+// sharedScrapes runs concurrent scrapes of the same key once.
+type sharedScrapes struct {
+	mu       sync.Mutex
+	inFlight map[string]*sharedScrape
+}
+
+func newSharedScrapes() *sharedScrapes {
+	return &sharedScrapes{inFlight: make(map[string]*sharedScrape)}
+}
+
+// do runs scrape once for all concurrent callers with the same key, on a
+// sharedContext, so that neither the deadline nor the cancellation of the
+// first caller decides the result for all. It returns whether the scrape
+// was shared with another caller, and no samples if ctx is done before the
+// scrape, unless ctx was the last to pass its deadline: the scrape then ends
+// at the same time and its partial results are returned.
+func (g *sharedScrapes) do(ctx context.Context, key string, scrape func(ctx context.Context) []prometheus.Metric) ([]prometheus.Metric, bool) {
+	g.mu.Lock()
+	s, shared := g.inFlight[key]
+	if !shared {
+		s = &sharedScrape{ctx: newSharedContext(), done: make(chan struct{})}
+		g.inFlight[key] = s
+	}
+	s.ctx.join(ctx)
+	g.mu.Unlock()
+
+	if !shared {
+		go func() {
+			s.samples = scrape(s.ctx)
+			g.mu.Lock()
+			delete(g.inFlight, key)
+			g.mu.Unlock()
+			s.ctx.cancel()
+			close(s.done)
+		}()
+	}
+	select {
+	case <-s.done:
+		return s.samples, shared
+	case <-ctx.Done():
+		s.ctx.leave()
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && s.ctx.Err() != nil {
+			<-s.done
+			return s.samples, shared
+		}
+		return nil, shared
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/shatteredsilicon/snmp_exporter/config"
+)
+
+func TestScrapeCache(t *testing.T) {
+	cache := newScrapeCache()
+	now := time.Now()
+	key := scrapeKey("192.0.0.8", "public_v2", "if_mib", config.DefaultWalkParams)
+	samples := []prometheus.Metric{
+		prometheus.MustNewConstMetric(prometheus.NewDesc("test", "test", nil, nil), prometheus.GaugeValue, 1),
+	}
+
+	if _, ok := cache.get(key, now); ok {
+		t.Fatal("expected empty cache")
+	}
+	cache.put(key, samples, now, 30*time.Second)
+	if got, ok := cache.get(key, now.Add(10*time.Second)); !ok || len(got) != 1 {
+		t.Errorf("expected cached samples within the interval, got %v", got)
+	}
+	if _, ok := cache.get(scrapeKey("192.0.0.8", "public_v3", "if_mib", config.DefaultWalkParams), now); ok {
+		t.Error("expected no cached samples for a different auth")
+	}
+	retries := 0
+	params := config.DefaultWalkParams.Override(config.WalkParams{Retries: &retries})
+	if _, ok := cache.get(scrapeKey("192.0.0.8", "public_v2", "if_mib", params), now); ok {
+		t.Error("expected no cached samples for different walk parameters")
+	}
+	if _, ok := cache.get(key, now.Add(30*time.Second)); ok {
+		t.Error("expected cached samples to expire after the interval")
+	}
+	if len(cache.entries) != 0 {
+		t.Errorf("expected expired entry to be removed, got %d entries", len(cache.entries))
+	}
+
+	cache.put(key, samples, now, time.Minute)
+	cache.put(scrapeKey("192.0.0.9", "public_v2", "if_mib", config.DefaultWalkParams), samples, now.Add(2*time.Minute), time.Minute)
+	if len(cache.entries) != 1 {
+		t.Errorf("expected expired entries to be swept on put, got %d entries", len(cache.entries))
+	}
+
+	cache.reset()
+	if len(cache.entries) != 0 {
+		t.Errorf("expected reset to empty the cache, got %d entries", len(cache.entries))
+	}
+}
+
+func TestSharedScrapes(t *testing.T) {
+	g := newSharedScrapes()
+	samples := []prometheus.Metric{
+		prometheus.MustNewConstMetric(prometheus.NewDesc("test", "test", nil, nil), prometheus.GaugeValue, 1),
+	}
+
+	// The first caller gives up at its deadline, the scrape goes on for the
+	// second caller with a later deadline.
+	short, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	long, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	started := make(chan struct{})
+	var deadline time.Time
+	var scrapeErr error
+	first := make(chan []prometheus.Metric)
+	go func() {
+		got, _ := g.do(short, "key", func(ctx context.Context) []prometheus.Metric {
+			close(started)
+			time.Sleep(200 * time.Millisecond)
+			deadline, _ = ctx.Deadline()
+			scrapeErr = ctx.Err()
+			return samples
+		})
+		first <- got
+	}()
+	<-started
+	got, shared := g.do(long, "key", func(ctx context.Context) []prometheus.Metric {
+		t.Error("expected the scrape to be shared")
+		return nil
+	})
+	if !shared || len(got) != 1 {
+		t.Errorf("expected the samples of the shared scrape, got %v (shared %t)", got, shared)
+	}
+	if got := <-first; got != nil {
+		t.Errorf("expected no samples for the caller past its deadline, got %v", got)
+	}
+	if scrapeErr != nil {
+		t.Errorf("expected the scrape to outlive the first caller, got %v", scrapeErr)
+	}
+	if want, _ := long.Deadline(); !deadline.Equal(want) {
+		t.Errorf("expected the deadline of the second caller %s, got %s", want, deadline)
+	}
+
+	// The scrape ends at the deadline, or once all callers are gone.
+	for _, want := range []error{context.DeadlineExceeded, context.Canceled} {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		if want == context.Canceled {
+			cancel()
+			ctx, cancel = context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+		}
+		errs := make(chan error, 1)
+		g.do(ctx, "key", func(ctx context.Context) []prometheus.Metric {
+			<-ctx.Done()
+			errs <- ctx.Err()
+			return nil
+		})
+		if err := <-errs; err != want {
+			t.Errorf("expected scrape to end with %v, got %v", want, err)
+		}
+		cancel()
+	}
+}
+
+func TestCollectShared(t *testing.T) {
+	agent := startTestAgent(t)
+	agent.SetLatency(200 * time.Millisecond)
+	ResetScrapeCache()
+	t.Cleanup(ResetScrapeCache)
+
+	auth := &config.Auth{Community: "public", SecurityLevel: "noAuthNoPriv", Version: 2}
+	retries := 0
+	module := &config.Module{
+		Get:         []string{"1.3.6.1.2.1.1.3.0"},
+		Metrics:     []*config.Metric{{Name: "sysUpTime", Oid: "1.3.6.1.2.1.1.3", Type: "gauge", Help: "Uptime"}},
+		WalkParams:  config.WalkParams{Timeout: time.Second, Retries: &retries},
+		MinInterval: time.Minute,
+	}
+	newCollector := func(ctx context.Context) *Collector {
+		return New(ctx, agent.Addr(), []*NamedAuth{NewNamedAuth("public_v2", auth)},
+			[]*NamedModule{NewNamedModule("shared", module)}, log.NewNopLogger(), testMetrics(), 1)
+	}
+
+	// Concurrent scrapes with different deadlines share one walk.
+	outputs := make(chan string, 2)
+	for _, timeout := range []time.Duration{2 * time.Second, 4 * time.Second} {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		go func(c *Collector) {
+			out, err := collectText(c)
+			if err != nil {
+				out = err.Error()
+			}
+			outputs <- out
+		}(newCollector(ctx))
+	}
+	for i := 0; i < 2; i++ {
+		out := <-outputs
+		for _, want := range []string{`snmp_scrape_cached{module="shared"} 0`, "sysUpTime 123456"} {
+			if !strings.Contains(out, want) {
+				t.Errorf("expected %q in output:\n%s", want, out)
+			}
+		}
+	}
+	if got := agent.Requests(); got != 1 {
+		t.Errorf("expected concurrent scrapes to send 1 request, got %d", got)
+	}
+
+	// Scrapes within min_interval are served from the cache.
+	out, err := collectText(newCollector(context.Background()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`snmp_scrape_cached{module="shared"} 1`, "sysUpTime 123456"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in cached output:\n%s", want, out)
+		}
+	}
+	if got := agent.Requests(); got != 1 {
+		t.Errorf("expected cached scrape to send no request, got %d requests", got)
+	}
+
+	// A target overriding the walk parameters of the module is scraped
+	// with its own.
+	overridden := *module
+	overridden.WalkParams.MaxRepetitions = 10
+	c := New(context.Background(), agent.Addr(), []*NamedAuth{NewNamedAuth("public_v2", auth)},
+		[]*NamedModule{NewNamedModule("shared", &overridden)}, log.NewNopLogger(), testMetrics(), 1)
+	out, err = collectText(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, `snmp_scrape_cached{module="shared"} 0`) {
+		t.Errorf("expected a scrape with other walk parameters not to be served from the cache:\n%s", out)
+	}
+	if got := agent.Requests(); got != 2 {
+		t.Errorf("expected the scrape with other walk parameters to send a request, got %d requests", got)
+	}
+}
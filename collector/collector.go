@@ -16,12 +16,14 @@ package collector
 import (
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/alecthomas/kingpin/v2"
@@ -77,62 +79,294 @@ func listToOid(l []int) string {
 }
 
 type ScrapeResults struct {
-	pdus    []gosnmp.SnmpPDU
-	packets uint64
-	retries uint64
+	pdus             []gosnmp.SnmpPDU
+	packets          uint64
+	retries          uint64
+	subtreeErrors    []subtreeError
+	subtreeDurations []subtreeDuration
+	// Set if the scrape deadline passed before all gets and walks were done.
+	deadlineExceeded bool
+	// The max repetitions learned for the target, with adaptive max repetitions.
+	maxRepetitions uint32
 }
 
-func ScrapeTarget(ctx context.Context, target string, auth *config.Auth, module *config.Module, logger log.Logger, metrics Metrics) (ScrapeResults, error) {
-	results := ScrapeResults{}
+// subtreeError is a get or walk that failed in a module with partial
+// results enabled.
+type subtreeError struct {
+	oid string
+	err error
+}
+
+// subtreeDuration is how long the walk of a single subtree took.
+type subtreeDuration struct {
+	oid      string
+	duration time.Duration
+}
+
+// newSession builds an unconnected session for the target, auth and walk
+// parameters.
+func newSession(target string, auth *config.Auth, params config.WalkParams) (*gosnmp.GoSNMP, error) {
 	// Set the options.
-	snmp := gosnmp.GoSNMP{}
-	snmp.Context = ctx
-	snmp.MaxRepetitions = module.WalkParams.MaxRepetitions
-	snmp.Retries = *module.WalkParams.Retries
-	snmp.Timeout = module.WalkParams.Timeout
-	snmp.UseUnconnectedUDPSocket = module.WalkParams.UseUnconnectedUDPSocket
+	snmp := &gosnmp.GoSNMP{}
+	snmp.MaxRepetitions = params.MaxRepetitions
+	snmp.Retries = *params.Retries
+	snmp.Timeout = params.Timeout
+	snmp.UseUnconnectedUDPSocket = config.Enabled(params.UseUnconnectedUDPSocket)
 	snmp.LocalAddr = *srcAddress
 
 	// Allow a set of OIDs that aren't in a strictly increasing order
-	if module.WalkParams.AllowNonIncreasingOIDs {
+	if config.Enabled(params.AllowNonIncreasingOIDs) {
 		snmp.AppOpts = make(map[string]interface{})
 		snmp.AppOpts["c"] = true
 	}
 
+	// Configure target.
+	if err := configureTarget(snmp, target); err != nil {
+		return nil, err
+	}
+
+	// Configure auth.
+	auth.ConfigureSNMP(snmp)
+	return snmp, nil
+}
+
+// openSession returns a connected session for the scrape, taken from the
+// session pool if possible. Packets sent on it are accounted to results.
+func openSession(ctx context.Context, key sessionKey, target string, auth *config.Auth, params config.WalkParams, results *ScrapeResults, metrics Metrics) (*gosnmp.GoSNMP, error) {
+	snmp := sessions.get(key, metrics)
+	connected := snmp != nil
+	if !connected {
+		var err error
+		snmp, err = newSession(target, auth, params)
+		if err != nil {
+			return nil, err
+		}
+	}
+	snmp.Context = ctx
+	snmp.MaxRepetitions = params.MaxRepetitions
+	fitTimeout(snmp, params)
+
+	snmp.PreSend = func(x *gosnmp.GoSNMP) {
+		// gosnmp has already set the deadline of the request, which must
+		// only start once the packet is sent.
+		if pacer.wait(ctx, target) {
+			x.Conn.SetDeadline(requestDeadline(x))
+		}
+	}
 	var sent time.Time
 	snmp.OnSent = func(x *gosnmp.GoSNMP) {
 		sent = time.Now()
 		metrics.SNMPPackets.Inc()
-		results.packets++
+		atomic.AddUint64(&results.packets, 1)
 	}
 	snmp.OnRecv = func(x *gosnmp.GoSNMP) {
 		metrics.SNMPDuration.Observe(time.Since(sent).Seconds())
 	}
 	snmp.OnRetry = func(x *gosnmp.GoSNMP) {
 		metrics.SNMPRetries.Inc()
-		results.retries++
+		atomic.AddUint64(&results.retries, 1)
 	}
 
-	// Configure target.
-	if err := configureTarget(&snmp, target); err != nil {
-		return results, err
+	if !connected {
+		connectStart := time.Now()
+		if err := snmp.Connect(); err != nil {
+			if err == context.Canceled {
+				return nil, fmt.Errorf("scrape cancelled after %s (possible timeout) connecting to target %s: %w",
+					time.Since(connectStart), snmp.Target, err)
+			}
+			return nil, fmt.Errorf("error connecting to target %s: %w", target, err)
+		}
+	}
+	return snmp, nil
+}
+
+// releaseSession hands a session back to the pool, or closes it if it
+// must not be reused.
+func releaseSession(key sessionKey, snmp *gosnmp.GoSNMP, reuse bool) {
+	if reuse {
+		sessions.put(key, snmp)
+	} else {
+		snmp.Conn.Close()
 	}
+}
 
-	// Configure auth.
-	auth.ConfigureSNMP(&snmp)
+// subtreeWalk is the outcome of walking a single subtree.
+type subtreeWalk struct {
+	walked   bool
+	pdus     []gosnmp.SnmpPDU
+	err      error
+	duration time.Duration
+}
+
+// fitTimeout shortens the timeout of the session so that a request and all
+// of its retries fit in what's left until the deadline of the scrape.
+func fitTimeout(snmp *gosnmp.GoSNMP, params config.WalkParams) {
+	snmp.Timeout = params.Timeout
+	deadline, ok := snmp.Context.Deadline()
+	if !ok {
+		return
+	}
+	budget := time.Until(deadline) / time.Duration(snmp.Retries+1)
+	if budget > 0 && budget < snmp.Timeout {
+		snmp.Timeout = budget
+	}
+}
+
+// requestDeadline returns the deadline of a request sent now, like gosnmp
+// sets it.
+func requestDeadline(snmp *gosnmp.GoSNMP) time.Time {
+	deadline := time.Now().Add(snmp.Timeout)
+	if ctxDeadline, ok := snmp.Context.Deadline(); ok && ctxDeadline.Before(deadline) {
+		return ctxDeadline
+	}
+	return deadline
+}
+
+// maxRepetitions returns the configured max repetitions, which is the upper
+// bound for adaptive max repetitions.
+func maxRepetitions(params config.WalkParams) uint32 {
+	if params.MaxRepetitions == 0 {
+		return defaultMaxRepetitions
+	}
+	return params.MaxRepetitions
+}
+
+// repetitionsTTL returns how long the max repetitions learned for a target
+// are kept.
+func repetitionsTTL(params config.WalkParams) time.Duration {
+	if params.AdaptiveMaxRepetitionsTTL == 0 {
+		return defaultRepetitionsTTL
+	}
+	return params.AdaptiveMaxRepetitionsTTL
+}
+
+func walkSubtree(snmp *gosnmp.GoSNMP, target, subtree string, params config.WalkParams, partial bool, logger log.Logger) subtreeWalk {
+	level.Debug(logger).Log("msg", "Walking subtree", "oid", subtree)
+	adaptive := config.Enabled(params.AdaptiveMaxRepetitions) && snmp.Version != gosnmp.Version1
+	// Without partial results or adaptive max repetitions, nothing acts on
+	// an error status, so the subtree is kept up to it.
+	endOnErrorStatus := !partial && !adaptive
+	ttl := repetitionsTTL(params)
+	walkStart := time.Now()
+	var w subtreeWalk
+	timedOut := false
+	for {
+		fitTimeout(snmp, params)
+		snmp.MaxRepetitions = params.MaxRepetitions
+		if adaptive {
+			snmp.MaxRepetitions = learnedRepetitions.get(target, maxRepetitions(params), ttl, time.Now())
+		}
+		w.pdus, w.err = walkAll(snmp, subtree, endOnErrorStatus)
+		if !adaptive {
+			break
+		}
+		if w.err == nil {
+			learnedRepetitions.increase(target, maxRepetitions(params), ttl, time.Now())
+			break
+		}
+		// Devices that can't send large responses reply with tooBig, or
+		// not at all if the response would be fragmented. A timeout can
+		// as well be a device that's down, so it's only retried once.
+		reason := errorReason(w.err)
+		if snmp.Context.Err() != nil || snmp.MaxRepetitions <= 1 {
+			break
+		}
+		if reason == reasonTimeout {
+			if timedOut {
+				break
+			}
+			timedOut = true
+		} else if reason != reasonTooBig {
+			break
+		}
+		reps := learnedRepetitions.decrease(target, snmp.MaxRepetitions, ttl, time.Now())
+		level.Debug(logger).Log("msg", "Walk of subtree failed, walking it again with lower max repetitions", "oid", subtree,
+			"reason", reason, "max_repetitions", reps)
+	}
+	w.walked = true
+	w.duration = time.Since(walkStart)
+	if w.err == nil {
+		level.Debug(logger).Log("msg", "Walk of subtree completed", "oid", subtree, "duration_seconds", w.duration)
+	}
+	return w
+}
+
+// walkSubtrees walks the subtrees over the given sessions, one worker per
+// session. The walks are returned in the order of the subtrees. Unless
+// partial is set, no further subtrees are started after a failed walk.
+func walkSubtrees(walkers []*gosnmp.GoSNMP, target string, subtrees []string, params config.WalkParams, partial bool, logger log.Logger) []subtreeWalk {
+	walks := make([]subtreeWalk, len(subtrees))
+	if len(walkers) == 1 {
+		for i, subtree := range subtrees {
+			walks[i] = walkSubtree(walkers[0], target, subtree, params, partial, logger)
+			if walks[i].err != nil && !partial {
+				break
+			}
+		}
+		return walks
+	}
+
+	var failed int32
+	wg := sync.WaitGroup{}
+	subtreeChan := make(chan int)
+	for _, snmp := range walkers {
+		wg.Add(1)
+		go func(snmp *gosnmp.GoSNMP) {
+			defer wg.Done()
+			for i := range subtreeChan {
+				// The subtree may have been handed out while another walk
+				// was failing.
+				if atomic.LoadInt32(&failed) != 0 {
+					continue
+				}
+				walks[i] = walkSubtree(snmp, target, subtrees[i], params, partial, logger)
+				if walks[i].err != nil && !partial {
+					atomic.StoreInt32(&failed, 1)
+				}
+			}
+		}(snmp)
+	}
+	for i := range subtrees {
+		if atomic.LoadInt32(&failed) != 0 {
+			break
+		}
+		subtreeChan <- i
+	}
+	close(subtreeChan)
+	wg.Wait()
+	return walks
+}
+
+func ScrapeTarget(ctx context.Context, target string, auth *config.Auth, module *config.Module, logger log.Logger, metrics Metrics) (ScrapeResults, error) {
+	if name, ok := strings.CutPrefix(target, ReplayScheme); ok {
+		return replayTarget(name, module, logger, metrics)
+	}
+	results, err := scrapeTarget(ctx, target, auth, module, logger, metrics)
+	if err == nil && *recordDir != "" {
+		if err := recordPdus(*recordDir, target, results.pdus); err != nil {
+			level.Warn(logger).Log("msg", "Error recording PDUs", "dir", *recordDir, "err", err)
+		}
+	}
+	return results, err
+}
+
+func scrapeTarget(ctx context.Context, target string, auth *config.Auth, module *config.Module, logger log.Logger, metrics Metrics) (ScrapeResults, error) {
+	results := ScrapeResults{}
+	key := newSessionKey(target, auth, module.WalkParams)
 
 	// Do the actual walk.
 	getInitialStart := time.Now()
-	err := snmp.Connect()
+	snmp, err := openSession(ctx, key, target, auth, module.WalkParams, &results, metrics)
 	if err != nil {
-		if err == context.Canceled {
-			return results, fmt.Errorf("scrape cancelled after %s (possible timeout) connecting to target %s",
-				time.Since(getInitialStart), snmp.Target)
-		}
-		return results, fmt.Errorf("error connecting to target %s: %s", target, err)
+		return results, err
 	}
-	defer snmp.Conn.Close()
+	// Only sessions that completed a scrape without errors are reused.
+	reuse := false
+	defer func() {
+		releaseSession(key, snmp, reuse)
+	}()
 
+	partial := module.OnError == config.OnErrorPartial
 	// Evaluate rules.
 	newGet := module.Get
 	newWalk := module.Walk
@@ -140,14 +374,11 @@ func ScrapeTarget(ctx context.Context, target string, auth *config.Auth, module
 		var pdus []gosnmp.SnmpPDU
 		allowedList := []string{}
 
-		if snmp.Version == gosnmp.Version1 {
-			pdus, err = snmp.WalkAll(filter.Oid)
-		} else {
-			pdus, err = snmp.BulkWalkAll(filter.Oid)
-		}
+		fitTimeout(snmp, module.WalkParams)
+		pdus, err = walkAll(snmp, filter.Oid, !partial)
 		// Do not try to filter anything if we had errors.
 		if err != nil {
-			level.Info(logger).Log("msg", "Error getting OID, won't do any filter on this oid", "oid", filter.Oid)
+			level.Info(logger).Log("msg", "Error getting OID, won't do any filter on this oid", "oid", filter.Oid, "reason", errorReason(err), "err", err)
 			continue
 		}
 
@@ -179,13 +410,28 @@ func ScrapeTarget(ctx context.Context, target string, auth *config.Auth, module
 
 		level.Debug(logger).Log("msg", "Getting OIDs", "oids", oids)
 		getStart := time.Now()
+		fitTimeout(snmp, module.WalkParams)
 		packet, err := snmp.Get(getOids[:oids])
 		if err != nil {
 			if err == context.Canceled {
-				return results, fmt.Errorf("scrape cancelled after %s (possible timeout) getting target %s",
-					time.Since(getInitialStart), snmp.Target)
+				return results, fmt.Errorf("scrape cancelled after %s (possible timeout) getting target %s: %w",
+					time.Since(getInitialStart), snmp.Target, err)
+			}
+			if errors.Is(err, context.DeadlineExceeded) {
+				level.Info(logger).Log("msg", "Scrape deadline exceeded getting OIDs, returning collected results", "oids", strings.Join(getOids[:oids], ","),
+					"duration_seconds", time.Since(getInitialStart))
+				results.deadlineExceeded = true
+				return results, nil
 			}
-			return results, fmt.Errorf("error getting target %s: %s", snmp.Target, err)
+			if partial {
+				level.Info(logger).Log("msg", "Error getting OIDs, skipping them", "oids", strings.Join(getOids[:oids], ","), "reason", errorReason(err), "err", err)
+				for _, oid := range getOids[:oids] {
+					results.subtreeErrors = append(results.subtreeErrors, subtreeError{oid: oid, err: err})
+				}
+				getOids = getOids[oids:]
+				continue
+			}
+			return results, fmt.Errorf("error getting OIDs %s from target %s: %w", strings.Join(getOids[:oids], ","), snmp.Target, err)
 		}
 		level.Debug(logger).Log("msg", "Get of OIDs completed", "oids", oids, "duration_seconds", time.Since(getStart))
 		// SNMPv1 will return packet error for unsupported OIDs.
@@ -195,9 +441,18 @@ func ScrapeTarget(ctx context.Context, target string, auth *config.Auth, module
 			continue
 		}
 		// Response received with errors.
-		// TODO: "stringify" gosnmp errors instead of showing error code.
 		if packet.Error != gosnmp.NoError {
-			return results, fmt.Errorf("error reported by target %s: Error Status %d", snmp.Target, packet.Error)
+			statusErr := newErrorStatusError(packet, getOids[:oids])
+			err := fmt.Errorf("error getting target %s: %w", snmp.Target, statusErr)
+			if partial {
+				level.Info(logger).Log("msg", "Error getting OIDs, skipping them", "oids", strings.Join(getOids[:oids], ","), "oid", statusErr.oid, "reason", errorReason(err), "err", err)
+				for _, oid := range getOids[:oids] {
+					results.subtreeErrors = append(results.subtreeErrors, subtreeError{oid: oid, err: err})
+				}
+				getOids = getOids[oids:]
+				continue
+			}
+			return results, err
 		}
 		for _, v := range packet.Variables {
 			if v.Type == gosnmp.NoSuchObject || v.Type == gosnmp.NoSuchInstance {
@@ -209,31 +464,71 @@ func ScrapeTarget(ctx context.Context, target string, auth *config.Auth, module
 		getOids = getOids[oids:]
 	}
 
-	for _, subtree := range newWalk {
-		var pdus []gosnmp.SnmpPDU
-		level.Debug(logger).Log("msg", "Walking subtree", "oid", subtree)
-		walkStart := time.Now()
-		if snmp.Version == gosnmp.Version1 {
-			pdus, err = snmp.WalkAll(subtree)
-		} else {
-			pdus, err = snmp.BulkWalkAll(subtree)
-		}
+	// Additional sessions for walking subtrees in parallel.
+	walkers := []*gosnmp.GoSNMP{snmp}
+	workers := module.WalkParams.WalkConcurrency
+	if workers > len(newWalk) {
+		workers = len(newWalk)
+	}
+	for len(walkers) < workers {
+		walker, err := openSession(ctx, key, target, auth, module.WalkParams, &results, metrics)
 		if err != nil {
-			if err == context.Canceled {
-				return results, fmt.Errorf("scrape canceled after %s (possible timeout) walking target %s",
-					time.Since(getInitialStart), snmp.Target)
-			}
-			return results, fmt.Errorf("error walking target %s: %s", snmp.Target, err)
+			level.Debug(logger).Log("msg", "Error opening additional session, walking with fewer sessions", "sessions", len(walkers), "err", err)
+			break
+		}
+		walkers = append(walkers, walker)
+	}
+	defer func() {
+		for _, walker := range walkers[1:] {
+			releaseSession(key, walker, reuse)
 		}
-		level.Debug(logger).Log("msg", "Walk of subtree completed", "oid", subtree, "duration_seconds", time.Since(walkStart))
+	}()
 
-		results.pdus = append(results.pdus, pdus...)
+	walks := walkSubtrees(walkers, target, newWalk, module.WalkParams, partial, logger)
+	for i, subtree := range newWalk {
+		w := walks[i]
+		if !w.walked {
+			continue
+		}
+		if w.err != nil {
+			if w.err == context.Canceled {
+				return results, fmt.Errorf("scrape canceled after %s (possible timeout) walking target %s: %w",
+					time.Since(getInitialStart), snmp.Target, w.err)
+			}
+			if errors.Is(w.err, context.DeadlineExceeded) {
+				level.Info(logger).Log("msg", "Scrape deadline exceeded walking subtree, skipping it", "oid", subtree,
+					"duration_seconds", time.Since(getInitialStart))
+				results.deadlineExceeded = true
+				continue
+			}
+			if partial {
+				level.Info(logger).Log("msg", "Error walking subtree, skipping it", "oid", subtree, "reason", errorReason(w.err), "err", w.err)
+				results.subtreeErrors = append(results.subtreeErrors, subtreeError{oid: subtree, err: w.err})
+				continue
+			}
+			return results, fmt.Errorf("error walking OID %s of target %s: %w", subtree, snmp.Target, w.err)
+		}
+		results.subtreeDurations = append(results.subtreeDurations, subtreeDuration{oid: subtree, duration: w.duration})
+		results.pdus = append(results.pdus, w.pdus...)
+	}
+	if config.Enabled(module.WalkParams.AdaptiveMaxRepetitions) && snmp.Version != gosnmp.Version1 {
+		results.maxRepetitions = learnedRepetitions.get(target, maxRepetitions(module.WalkParams), repetitionsTTL(module.WalkParams), time.Now())
 	}
+	reuse = len(results.subtreeErrors) == 0 && !results.deadlineExceeded
 	return results, nil
 }
 
 func configureTarget(g *gosnmp.GoSNMP, target string) error {
 	if s := strings.SplitN(target, "://", 2); len(s) == 2 {
+		switch s[0] {
+		case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6":
+		case "tls", "dtls":
+			// RFC 6353 requires the transport security model, gosnmp only
+			// implements the user-based security model.
+			return fmt.Errorf("transport %q of target %q is not supported, SNMP over TLS and DTLS is not implemented", s[0], target)
+		default:
+			return fmt.Errorf("unknown transport %q for target %q", s[0], target)
+		}
 		g.Transport = s[0]
 		target = s[1]
 	}
@@ -350,6 +645,8 @@ type Metrics struct {
 	SNMPDuration           prometheus.Histogram
 	SNMPPackets            prometheus.Counter
 	SNMPRetries            prometheus.Counter
+	SNMPSessionPoolHits    prometheus.Counter
+	SNMPSessionPoolMisses  prometheus.Counter
 }
 
 type NamedModule struct {
@@ -365,8 +662,10 @@ func NewNamedModule(name string, module *config.Module) *NamedModule {
 }
 
 type Collector struct {
-	ctx         context.Context
-	target      string
+	ctx    context.Context
+	target string
+	auths  []*NamedAuth
+	// The auth selected of auths.
 	auth        *config.Auth
 	authName    string
 	modules     []*NamedModule
@@ -375,8 +674,10 @@ type Collector struct {
 	concurrency int
 }
 
-func New(ctx context.Context, target, authName string, auth *config.Auth, modules []*NamedModule, logger log.Logger, metrics Metrics, conc int) *Collector {
-	return &Collector{ctx: ctx, target: target, authName: authName, auth: auth, modules: modules, logger: logger, metrics: metrics, concurrency: conc}
+// New returns a collector for the modules of the target. With more than one
+// auth, the first that works for the target is used.
+func New(ctx context.Context, target string, auths []*NamedAuth, modules []*NamedModule, logger log.Logger, metrics Metrics, conc int) *Collector {
+	return &Collector{ctx: ctx, target: target, auths: auths, auth: auths[0].Auth, authName: auths[0].name, modules: modules, logger: logger, metrics: metrics, concurrency: conc}
 }
 
 // Describe implements Prometheus.Collector.
@@ -384,15 +685,23 @@ func (c Collector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- prometheus.NewDesc("dummy", "dummy", nil, nil)
 }
 
-func (c Collector) collect(ch chan<- prometheus.Metric, module *NamedModule) {
+// collect scrapes a module and sends its samples to ch. It returns false
+// if the scrape failed or didn't finish before the scrape deadline.
+func (c Collector) collect(ch chan<- prometheus.Metric, module *NamedModule) bool {
 	logger := log.With(c.logger, "module", module.name)
 	start := time.Now()
 	results, err := ScrapeTarget(c.ctx, c.target, c.auth, module.Module, logger, c.metrics)
 	moduleLabel := prometheus.Labels{"module": module.name}
 	if err != nil {
-		level.Info(logger).Log("msg", "Error scraping target", "err", err)
-		ch <- prometheus.NewInvalidMetric(prometheus.NewDesc("snmp_error", "Error scraping target", nil, moduleLabel), err)
-		return
+		reason := errorReason(err)
+		level.Info(logger).Log("msg", "Error scraping target", "reason", reason, "err", err)
+		if len(c.auths) > 1 && (reason == reasonAuthFailure || reason == reasonTimeout) {
+			// Look for a working auth again in the next scrape. A canceled
+			// scrape says nothing about the auth.
+			selectedAuths.delete(authCacheKey(c.target, c.auths))
+		}
+		ch <- prometheus.NewInvalidMetric(prometheus.NewDesc("snmp_error", "Error scraping target", nil, moduleLabel), fmt.Errorf("%s: %w", reason, err))
+		return false
 	}
 	ch <- prometheus.MustNewConstMetric(
 		prometheus.NewDesc("snmp_scrape_walk_duration_seconds", "Time SNMP walk/bulkwalk took.", nil, moduleLabel),
@@ -410,27 +719,63 @@ func (c Collector) collect(ch chan<- prometheus.Metric, module *NamedModule) {
 		prometheus.NewDesc("snmp_scrape_pdus_returned", "PDUs returned from get, bulkget, and walk.", nil, moduleLabel),
 		prometheus.GaugeValue,
 		float64(len(results.pdus)))
+	deadlineExceeded := 0.0
+	if results.deadlineExceeded {
+		deadlineExceeded = 1.0
+	}
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc("snmp_scrape_deadline_exceeded", "Whether the scrape deadline passed before all gets and walks were done.", nil, moduleLabel),
+		prometheus.GaugeValue,
+		deadlineExceeded)
+	if results.maxRepetitions > 0 {
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc("snmp_scrape_max_repetitions", "Max repetitions learned for the target with adaptive max repetitions.", nil, moduleLabel),
+			prometheus.GaugeValue,
+			float64(results.maxRepetitions))
+	}
+	walkedOids := map[string]struct{}{}
+	for _, sd := range results.subtreeDurations {
+		if _, ok := walkedOids[sd.oid]; ok {
+			continue
+		}
+		walkedOids[sd.oid] = struct{}{}
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc("snmp_scrape_subtree_walk_duration_seconds", "Time the walk of a single subtree took.", []string{"oid"}, moduleLabel),
+			prometheus.GaugeValue,
+			sd.duration.Seconds(), sd.oid)
+	}
+	failedOids := map[string]struct{}{}
+	reasons := map[string]struct{}{}
+	for _, se := range results.subtreeErrors {
+		reason := errorReason(se.err)
+		if _, ok := reasons[reason]; !ok {
+			reasons[reason] = struct{}{}
+			ch <- prometheus.MustNewConstMetric(
+				prometheus.NewDesc("snmp_scrape_error_reason", "Reasons gets and walks failed in a module with partial results enabled.", []string{"reason"}, moduleLabel),
+				prometheus.GaugeValue,
+				1.0, reason)
+		}
+		if _, ok := failedOids[se.oid]; ok {
+			continue
+		}
+		failedOids[se.oid] = struct{}{}
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc("snmp_scrape_subtree_errors", "Gets and walks that failed in a module with partial results enabled.", []string{"oid"}, moduleLabel),
+			prometheus.GaugeValue,
+			1.0, se.oid)
+	}
 	oidToPdu := make(map[string]gosnmp.SnmpPDU, len(results.pdus))
 	for _, pdu := range results.pdus {
 		oidToPdu[pdu.Name[1:]] = pdu
 	}
 
-	if _, ok := ssmMetricRecords.current[c.target]; !ok {
-		ssmMetricRecords.current[c.target] = &ssmMetricRecord{
-			collectedMetrics: make(map[string]struct{}),
-			hrSWRunPerfCPU:   make(map[string]ssmMetricPerfCPU),
-			hrSWRunName:      make(map[string]string),
-		}
-	} else {
-		// reset current data if needed
-		ssmMetricRecords.current[c.target].hrSWRunPerfMem = 0
-		ssmMetricRecords.current[c.target].hrSWRunPerfCPU = make(map[string]ssmMetricPerfCPU)
-		ssmMetricRecords.current[c.target].hrSWRunName = make(map[string]string)
-	}
-	ssmMetricRecords.current[c.target].mu.Lock()
-	defer ssmMetricRecords.current[c.target].mu.Unlock()
+	ssmRecord := ssmMetricRecords.acquire(ssmRecordKey(c.target, c.authName, module.name))
+	defer ssmRecord.release()
+	current := ssmRecord.current
 
 	metricTree := buildMetricTree(module.Metrics)
+	computed := newComputedValues(module.ComputedMetrics)
+	r := newRelabeler(module.MetricRelabelConfigs, logger)
 	// Look for metrics that match each pdu.
 PduLoop:
 	for oid, pdu := range oidToPdu {
@@ -446,50 +791,51 @@ PduLoop:
 				continue
 			}
 
-			ssmMetricRecords.current[c.target].collectedMetrics[head.metric.Name] = struct{}{}
+			current.collectedMetrics[head.metric.Name] = struct{}{}
+			computed.add(head.metric, oidList[i+1:], &pdu)
 
 			// Found a match.
 			switch head.metric.Name {
 			case "ssCpuRawUser":
-				ssmMetricRecords.current[c.target].ssCPURawUser = getPduValue(&pdu)
+				current.ssCPURawUser = getPduValue(&pdu)
 			case "ssCpuRawNice":
-				ssmMetricRecords.current[c.target].ssCPURawNice = getPduValue(&pdu)
+				current.ssCPURawNice = getPduValue(&pdu)
 			case "ssCpuRawSystem":
-				ssmMetricRecords.current[c.target].ssCPURawSystem = getPduValue(&pdu)
+				current.ssCPURawSystem = getPduValue(&pdu)
 			case "ssCpuRawIdle":
-				ssmMetricRecords.current[c.target].ssCPURawIdle = getPduValue(&pdu)
+				current.ssCPURawIdle = getPduValue(&pdu)
 			case "ssCpuRawWait":
-				ssmMetricRecords.current[c.target].ssCPURawWait = getPduValue(&pdu)
+				current.ssCPURawWait = getPduValue(&pdu)
 			case "ssCpuRawKernel":
-				ssmMetricRecords.current[c.target].ssCPURawKernel = getPduValue(&pdu)
+				current.ssCPURawKernel = getPduValue(&pdu)
 			case "ssCpuRawInterrupt":
-				ssmMetricRecords.current[c.target].ssCPURawInterrupt = getPduValue(&pdu)
+				current.ssCPURawInterrupt = getPduValue(&pdu)
 			case "ssCpuRawSoftIRQ":
-				ssmMetricRecords.current[c.target].ssCPURawSoftIRQ = getPduValue(&pdu)
+				current.ssCPURawSoftIRQ = getPduValue(&pdu)
 			case "ssCpuRawSteal":
-				ssmMetricRecords.current[c.target].ssCPURawSteal = getPduValue(&pdu)
+				current.ssCPURawSteal = getPduValue(&pdu)
 			case "ssCpuRawGuest":
-				ssmMetricRecords.current[c.target].ssCPURawGuest = getPduValue(&pdu)
+				current.ssCPURawGuest = getPduValue(&pdu)
 			case "hrSystemDate":
-				ssmMetricRecords.current[c.target].hrSystemDate, _ = parseDateAndTime(&pdu)
+				current.hrSystemDate, _ = parseDateAndTime(&pdu)
 			case "hrSWRunPerfMem":
-				ssmMetricRecords.current[c.target].hrSWRunPerfMem = ssmMetricRecords.current[c.target].hrSWRunPerfMem + getPduValue(&pdu)
+				current.hrSWRunPerfMem = current.hrSWRunPerfMem + getPduValue(&pdu)
 			case "hrSWRunPerfCPU":
 				labels := indexesToLabels(oidList[i+1:], head.metric, oidToPdu, c.metrics)
-				ssmMetricRecords.current[c.target].hrSWRunPerfCPU[labels["hrSWRunIndex"]] = ssmMetricPerfCPU{
+				current.hrSWRunPerfCPU[labels["hrSWRunIndex"]] = ssmMetricPerfCPU{
 					hrSWRunType: labels["hrSWRunType"],
 					value:       getPduValue(&pdu),
 				}
 			case "hrSWRunName":
 				labels := indexesToLabels(oidList[i+1:], head.metric, oidToPdu, c.metrics)
-				ssmMetricRecords.current[c.target].hrSWRunName[labels["hrSWRunIndex"]] = string(pdu.Value.([]byte))
+				current.hrSWRunName[labels["hrSWRunIndex"]] = string(pdu.Value.([]byte))
 			default:
 				switch head.metric.Name {
 				case "hrMemorySize":
-					ssmMetricRecords.current[c.target].hrMemorySize = getPduValue(&pdu)
+					current.hrMemorySize = getPduValue(&pdu)
 				}
 
-				samples := pduToSamples(oidList[i+1:], &pdu, head.metric, oidToPdu, c.logger, c.metrics)
+				samples := pduToSamples(oidList[i+1:], &pdu, head.metric, oidToPdu, r, c.logger, c.metrics)
 				for _, sample := range samples {
 					ch <- sample
 				}
@@ -498,7 +844,11 @@ PduLoop:
 		}
 	}
 
-	samples, err := c.collecSSMCPUMetrics()
+	for _, sample := range computed.samples(oidToPdu, r, c.metrics) {
+		ch <- sample
+	}
+
+	samples, err := ssmRecord.collecSSMCPUMetrics()
 	if err != nil {
 		samples = append(samples, prometheus.NewInvalidMetric(prometheus.NewDesc("snmp_error", "Error calling collecSSMCPUMetrics", nil, nil),
 			fmt.Errorf("error for metric %s: %v", nodeCPUAverageName, err)))
@@ -507,7 +857,7 @@ PduLoop:
 		ch <- sample
 	}
 
-	samples, err = c.collectSSMMemoryMetrics()
+	samples, err = ssmRecord.collectSSMMemoryMetrics()
 	if err != nil {
 		samples = append(samples, prometheus.NewInvalidMetric(prometheus.NewDesc("snmp_error", "Error calling collectSSMMemoryMetrics", nil, nil),
 			fmt.Errorf("error for metric memory: %v", err)))
@@ -520,11 +870,76 @@ PduLoop:
 		prometheus.NewDesc("snmp_scrape_duration_seconds", "Total SNMP time scrape took (walk and processing).", nil, moduleLabel),
 		prometheus.GaugeValue,
 		time.Since(start).Seconds())
-	c.copyHistorySSMMetrics()
+	return !results.deadlineExceeded
+}
+
+// collectShared scrapes a module like collect, but shares the samples
+// between concurrent scrapes of the same target, auth, module and walk
+// parameters, and
+// serves them from the cache while the module's min_interval hasn't passed.
+func (c Collector) collectShared(ch chan<- prometheus.Metric, module *NamedModule) {
+	key := scrapeKey(c.target, c.authName, module.name, module.WalkParams)
+	cached := 0.0
+	samples, ok := scrapes.get(key, time.Now())
+	if ok {
+		level.Debug(c.logger).Log("msg", "Serving scrape from cache", "module", module.name)
+		cached = 1.0
+	} else {
+		var shared bool
+		samples, shared = scrapeGroup.do(c.ctx, key, func(ctx context.Context) []prometheus.Metric {
+			buf := make(chan prometheus.Metric)
+			done := make(chan []prometheus.Metric)
+			go func() {
+				samples := []prometheus.Metric{}
+				for sample := range buf {
+					samples = append(samples, sample)
+				}
+				done <- samples
+			}()
+			sc := c
+			sc.ctx = ctx
+			ok := sc.collect(buf, module)
+			close(buf)
+			samples := <-done
+			if ok && module.MinInterval > 0 {
+				scrapes.put(key, samples, time.Now(), module.MinInterval)
+			}
+			return samples
+		})
+		if shared {
+			level.Debug(c.logger).Log("msg", "Shared scrape with a concurrent request", "module", module.name)
+		}
+		if samples == nil {
+			// The request is gone, or its deadline passed before the scrape
+			// shared with requests with a later deadline was done.
+			level.Debug(c.logger).Log("msg", "Stopped waiting for shared scrape", "module", module.name, "err", c.ctx.Err())
+			ch <- prometheus.MustNewConstMetric(
+				prometheus.NewDesc("snmp_scrape_deadline_exceeded", "Whether the scrape deadline passed before all gets and walks were done.", nil, prometheus.Labels{"module": module.name}),
+				prometheus.GaugeValue,
+				1.0)
+		}
+	}
+	for _, sample := range samples {
+		ch <- sample
+	}
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc("snmp_scrape_cached", "Whether the samples of the module were served from cache.", nil, prometheus.Labels{"module": module.name}),
+		prometheus.GaugeValue,
+		cached)
 }
 
 // Collect implements Prometheus.Collector.
 func (c Collector) Collect(ch chan<- prometheus.Metric) {
+	auth := c.selectAuth()
+	c.auth, c.authName = auth.Auth, auth.name
+	if len(c.auths) > 1 {
+		c.logger = log.With(c.logger, "auth_selected", auth.name)
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc("snmp_auth_selected", "The auth of the list of auths the target is scraped with.", []string{"auth"}, nil),
+			prometheus.GaugeValue,
+			1.0, auth.name)
+	}
+
 	wg := sync.WaitGroup{}
 	workerCount := c.concurrency
 	if workerCount < 1 {
@@ -539,7 +954,7 @@ func (c Collector) Collect(ch chan<- prometheus.Metric) {
 				logger := log.With(c.logger, "module", m.name)
 				level.Debug(logger).Log("msg", "Starting scrape")
 				start := time.Now()
-				c.collect(ch, m)
+				c.collectShared(ch, m)
 				duration := time.Since(start).Seconds()
 				level.Debug(logger).Log("msg", "Finished scrape", "duration_seconds", duration)
 				c.metrics.SNMPCollectionDuration.WithLabelValues(m.name).Observe(duration)
@@ -618,7 +1033,9 @@ func parseDateAndTime(pdu *gosnmp.SnmpPDU) (float64, error) {
 	return float64(t.Unix()), nil
 }
 
-func pduToSamples(indexOids []int, pdu *gosnmp.SnmpPDU, metric *config.Metric, oidToPdu map[string]gosnmp.SnmpPDU, logger log.Logger, metrics Metrics) []prometheus.Metric {
+func pduToSamples(indexOids []int, pdu *gosnmp.SnmpPDU, metric *config.Metric, oidToPdu map[string]gosnmp.SnmpPDU, r relabeler, logger log.Logger, metrics Metrics) (samples []prometheus.Metric) {
+	// Leave out the samples dropped by the relabel rules.
+	defer func() { samples = withoutDropped(samples) }()
 	var err error
 	// The part of the OID that is the indexes.
 	labels := indexesToLabels(indexOids, metric, oidToPdu, metrics)
@@ -648,11 +1065,11 @@ func pduToSamples(indexOids []int, pdu *gosnmp.SnmpPDU, metric *config.Metric, o
 			return []prometheus.Metric{}
 		}
 	case config.MetricTypeEnumAsInfo:
-		return enumAsInfo(metric, int(value), labelnames, labelvalues)
+		return enumAsInfo(metric, int(value), labelnames, labelvalues, r)
 	case config.MetricTypeEnumAsStateSet:
-		return enumAsStateSet(metric, int(value), labelnames, labelvalues)
+		return enumAsStateSet(metric, int(value), labelnames, labelvalues, r)
 	case config.MetricTypeBits:
-		return bits(metric, pdu.Value, labelnames, labelvalues)
+		return bits(metric, pdu.Value, labelnames, labelvalues, r)
 	default:
 		// It's some form of string.
 		t = prometheus.GaugeValue
@@ -677,7 +1094,7 @@ func pduToSamples(indexOids []int, pdu *gosnmp.SnmpPDU, metric *config.Metric, o
 		}
 
 		if len(metric.RegexpExtracts) > 0 {
-			return applyRegexExtracts(metric, pduValueAsString(pdu, metricType, metrics), labelnames, labelvalues, logger)
+			return applyRegexExtracts(metric, pduValueAsString(pdu, metricType, metrics), labelnames, labelvalues, r, logger)
 		}
 		// For strings we put the value as a label with the same name as the metric.
 		// If the name is already an index, we do not need to set it again.
@@ -694,13 +1111,12 @@ func pduToSamples(indexOids []int, pdu *gosnmp.SnmpPDU, metric *config.Metric, o
 
 	var sample prometheus.Metric
 	if isSSMMetrics(metric) {
-		samples, err := newSSMConstMetric(metric, t, value, labelnames, labelvalues, nil)
+		samples, err := newSSMConstMetric(metric, t, value, labelnames, labelvalues, r)
 		if err == nil {
 			return samples
 		}
 	} else {
-		sample, err = prometheus.NewConstMetric(prometheus.NewDesc(metric.Name, metric.Help, labelnames, nil),
-			t, value, labelvalues...)
+		sample, err = r.newConstMetric(metric.Name, metric.Help, t, value, labelnames, labelvalues)
 	}
 	if err != nil {
 		sample = prometheus.NewInvalidMetric(prometheus.NewDesc("snmp_error", "Error calling NewConstMetric", nil, nil),
@@ -710,10 +1126,14 @@ func pduToSamples(indexOids []int, pdu *gosnmp.SnmpPDU, metric *config.Metric, o
 	return []prometheus.Metric{sample}
 }
 
-func applyRegexExtracts(metric *config.Metric, pduValue string, labelnames, labelvalues []string, logger log.Logger) []prometheus.Metric {
+func applyRegexExtracts(metric *config.Metric, pduValue string, labelnames, labelvalues []string, r relabeler, logger log.Logger) []prometheus.Metric {
 	results := []prometheus.Metric{}
 	for name, strMetricSlice := range metric.RegexpExtracts {
 		for _, strMetric := range strMetricSlice {
+			if strMetric.Regex.Regexp == nil {
+				// An empty regex in the configuration.
+				continue
+			}
 			indexes := strMetric.Regex.FindStringSubmatchIndex(pduValue)
 			if indexes == nil {
 				level.Debug(logger).Log("msg", "No match found for regexp", "metric", metric.Name, "value", pduValue, "regex", strMetric.Regex.String())
@@ -725,8 +1145,7 @@ func applyRegexExtracts(metric *config.Metric, pduValue string, labelnames, labe
 				level.Debug(logger).Log("msg", "Error parsing float64 from value", "metric", metric.Name, "value", pduValue, "regex", strMetric.Regex.String(), "extracted_value", res)
 				continue
 			}
-			newMetric, err := prometheus.NewConstMetric(prometheus.NewDesc(metric.Name+name, metric.Help+" (regex extracted)", labelnames, nil),
-				prometheus.GaugeValue, v, labelvalues...)
+			newMetric, err := r.newConstMetric(metric.Name+name, metric.Help+" (regex extracted)", prometheus.GaugeValue, v, labelnames, labelvalues)
 			if err != nil {
 				newMetric = prometheus.NewInvalidMetric(prometheus.NewDesc("snmp_error", "Error calling NewConstMetric for regex_extract", nil, nil),
 					fmt.Errorf("error for metric %s with labels %v: %v", metric.Name+name, labelvalues, err))
@@ -738,7 +1157,7 @@ func applyRegexExtracts(metric *config.Metric, pduValue string, labelnames, labe
 	return results
 }
 
-func enumAsInfo(metric *config.Metric, value int, labelnames, labelvalues []string) []prometheus.Metric {
+func enumAsInfo(metric *config.Metric, value int, labelnames, labelvalues []string, r relabeler) []prometheus.Metric {
 	// Lookup enum, default to the value.
 	state, ok := metric.EnumValues[int(value)]
 	if !ok {
@@ -747,8 +1166,7 @@ func enumAsInfo(metric *config.Metric, value int, labelnames, labelvalues []stri
 	labelnames = append(labelnames, metric.Name)
 	labelvalues = append(labelvalues, state)
 
-	newMetric, err := prometheus.NewConstMetric(prometheus.NewDesc(metric.Name+"_info", metric.Help+" (EnumAsInfo)", labelnames, nil),
-		prometheus.GaugeValue, 1.0, labelvalues...)
+	newMetric, err := r.newConstMetric(metric.Name+"_info", metric.Help+" (EnumAsInfo)", prometheus.GaugeValue, 1.0, labelnames, labelvalues)
 	if err != nil {
 		newMetric = prometheus.NewInvalidMetric(prometheus.NewDesc("snmp_error", "Error calling NewConstMetric for EnumAsInfo", nil, nil),
 			fmt.Errorf("error for metric %s with labels %v: %v", metric.Name, labelvalues, err))
@@ -756,7 +1174,7 @@ func enumAsInfo(metric *config.Metric, value int, labelnames, labelvalues []stri
 	return []prometheus.Metric{newMetric}
 }
 
-func enumAsStateSet(metric *config.Metric, value int, labelnames, labelvalues []string) []prometheus.Metric {
+func enumAsStateSet(metric *config.Metric, value int, labelnames, labelvalues []string, r relabeler) []prometheus.Metric {
 	labelnames = append(labelnames, metric.Name)
 	results := []prometheus.Metric{}
 
@@ -765,8 +1183,7 @@ func enumAsStateSet(metric *config.Metric, value int, labelnames, labelvalues []
 		// Fallback to using the value.
 		state = strconv.Itoa(value)
 	}
-	newMetric, err := prometheus.NewConstMetric(prometheus.NewDesc(metric.Name, metric.Help+" (EnumAsStateSet)", labelnames, nil),
-		prometheus.GaugeValue, 1.0, append(labelvalues, state)...)
+	newMetric, err := r.newConstMetric(metric.Name, metric.Help+" (EnumAsStateSet)", prometheus.GaugeValue, 1.0, labelnames, append(labelvalues, state))
 	if err != nil {
 		newMetric = prometheus.NewInvalidMetric(prometheus.NewDesc("snmp_error", "Error calling NewConstMetric for EnumAsStateSet", nil, nil),
 			fmt.Errorf("error for metric %s with labels %v: %v", metric.Name, labelvalues, err))
@@ -777,8 +1194,7 @@ func enumAsStateSet(metric *config.Metric, value int, labelnames, labelvalues []
 		if k == value {
 			continue
 		}
-		newMetric, err := prometheus.NewConstMetric(prometheus.NewDesc(metric.Name, metric.Help+" (EnumAsStateSet)", labelnames, nil),
-			prometheus.GaugeValue, 0.0, append(labelvalues, v)...)
+		newMetric, err := r.newConstMetric(metric.Name, metric.Help+" (EnumAsStateSet)", prometheus.GaugeValue, 0.0, labelnames, append(labelvalues, v))
 		if err != nil {
 			newMetric = prometheus.NewInvalidMetric(prometheus.NewDesc("snmp_error", "Error calling NewConstMetric for EnumAsStateSet", nil, nil),
 				fmt.Errorf("error for metric %s with labels %v: %v", metric.Name, labelvalues, err))
@@ -788,7 +1204,7 @@ func enumAsStateSet(metric *config.Metric, value int, labelnames, labelvalues []
 	return results
 }
 
-func bits(metric *config.Metric, value interface{}, labelnames, labelvalues []string) []prometheus.Metric {
+func bits(metric *config.Metric, value interface{}, labelnames, labelvalues []string, r relabeler) []prometheus.Metric {
 	bytes, ok := value.([]byte)
 	if !ok {
 		return []prometheus.Metric{prometheus.NewInvalidMetric(prometheus.NewDesc("snmp_error", "BITS type was not a BISTRING on the wire.", nil, nil),
@@ -805,8 +1221,7 @@ func bits(metric *config.Metric, value interface{}, labelnames, labelvalues []st
 				bit = 1.0
 			}
 		}
-		newMetric, err := prometheus.NewConstMetric(prometheus.NewDesc(metric.Name, metric.Help+" (Bits)", labelnames, nil),
-			prometheus.GaugeValue, bit, append(labelvalues, v)...)
+		newMetric, err := r.newConstMetric(metric.Name, metric.Help+" (Bits)", prometheus.GaugeValue, bit, labelnames, append(labelvalues, v))
 		if err != nil {
 			newMetric = prometheus.NewInvalidMetric(prometheus.NewDesc("snmp_error", "Error calling NewConstMetric for Bits", nil, nil),
 				fmt.Errorf("error for metric %s with labels %v: %v", metric.Name, labelvalues, err))
@@ -14,11 +14,13 @@
 package collector
 
 import (
+	"context"
 	"errors"
 	"reflect"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	kingpin "github.com/alecthomas/kingpin/v2"
 	"github.com/go-kit/log"
@@ -570,7 +572,7 @@ func TestPduToSample(t *testing.T) {
 	}
 
 	for _, c := range cases {
-		metrics := pduToSamples(c.indexOids, c.pdu, c.metric, c.oidToPdu, log.NewNopLogger(), Metrics{})
+		metrics := pduToSamples(c.indexOids, c.pdu, c.metric, c.oidToPdu, relabeler{}, log.NewNopLogger(), Metrics{})
 		metric := &io_prometheus_client.Metric{}
 		expected := map[string]struct{}{}
 		for _, e := range c.expectedMetrics {
@@ -1231,6 +1233,27 @@ func TestConfigureTarget(t *testing.T) {
 			gPort:      0,
 			shouldErr:  true,
 		},
+		{
+			target:     "tls://localhost:10161",
+			gTransport: "",
+			gTarget:    "",
+			gPort:      0,
+			shouldErr:  true,
+		},
+		{
+			target:     "dtls://localhost:10161",
+			gTransport: "",
+			gTarget:    "",
+			gPort:      0,
+			shouldErr:  true,
+		},
+		{
+			target:     "sctp://localhost",
+			gTransport: "",
+			gTarget:    "",
+			gPort:      0,
+			shouldErr:  true,
+		},
 	}
 
 	for _, c := range cases {
@@ -1401,3 +1424,30 @@ func TestAddAllowedIndices(t *testing.T) {
 		}
 	}
 }
+
+func TestFitTimeout(t *testing.T) {
+	retries := 3
+	params := config.WalkParams{Retries: &retries, Timeout: 5 * time.Second}
+
+	snmp := &gosnmp.GoSNMP{Context: context.Background(), Retries: retries}
+	fitTimeout(snmp, params)
+	if snmp.Timeout != 5*time.Second {
+		t.Errorf("Expected configured timeout without a deadline, got %s", snmp.Timeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+	snmp.Context = ctx
+	fitTimeout(snmp, params)
+	if snmp.Timeout > 2*time.Second || snmp.Timeout < time.Second {
+		t.Errorf("Expected timeout to be spread over retries within the deadline, got %s", snmp.Timeout)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	snmp.Context = ctx
+	fitTimeout(snmp, params)
+	if snmp.Timeout != 5*time.Second {
+		t.Errorf("Expected configured timeout with a distant deadline, got %s", snmp.Timeout)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/shatteredsilicon/snmp_exporter/config"
+)
+
+// computedValue is the value of a metric at an index.
+type computedValue struct {
+	value     float64
+	indexOids []int
+	metric    *config.Metric
+}
+
+// computedValues collects the values of the metrics the computed metrics of
+// a module refer to during a scrape, by metric name and index OIDs. Metrics
+// without indexes have a single value at the empty index.
+type computedValues struct {
+	computed []*config.ComputedMetric
+	values   map[string]map[string]computedValue
+}
+
+// newComputedValues returns nil if there are no computed metrics, which
+// collects nothing.
+func newComputedValues(computed []*config.ComputedMetric) *computedValues {
+	if len(computed) == 0 {
+		return nil
+	}
+	v := &computedValues{
+		computed: computed,
+		values:   map[string]map[string]computedValue{},
+	}
+	for _, cm := range computed {
+		for _, name := range cm.Expr.Metrics() {
+			v.values[name] = map[string]computedValue{}
+		}
+	}
+	return v
+}
+
+// add records the value of the PDU if a computed metric refers to the
+// metric.
+func (v *computedValues) add(metric *config.Metric, indexOids []int, pdu *gosnmp.SnmpPDU) {
+	if v == nil {
+		return
+	}
+	values, ok := v.values[metric.Name]
+	if !ok {
+		return
+	}
+	value := getPduValue(pdu)
+	if metric.Scale != 0.0 {
+		value *= metric.Scale
+	}
+	value += metric.Offset
+	index := ""
+	if len(metric.Indexes) > 0 {
+		index = listToOid(indexOids)
+	}
+	values[index] = computedValue{value: value, indexOids: indexOids, metric: metric}
+}
+
+// samples evaluates the computed metrics for each index of the metrics they
+// refer to. The samples get the labels of the first metric in the
+// expression that has indexes. Indexes missing a value of any of the
+// metrics are left out.
+func (v *computedValues) samples(oidToPdu map[string]gosnmp.SnmpPDU, r relabeler, metrics Metrics) []prometheus.Metric {
+	if v == nil {
+		return nil
+	}
+	var samples []prometheus.Metric
+	for _, cm := range v.computed {
+		names := cm.Expr.Metrics()
+		indexed := ""
+		for _, name := range names {
+			if _, ok := v.values[name][""]; !ok && len(v.values[name]) > 0 {
+				indexed = name
+				break
+			}
+		}
+		indexes := []string{""}
+		if indexed != "" {
+			indexes = make([]string, 0, len(v.values[indexed]))
+			for index := range v.values[indexed] {
+				indexes = append(indexes, index)
+			}
+			sort.Strings(indexes)
+		}
+
+		t := prometheus.GaugeValue
+		if cm.Type == config.MetricTypeCounter {
+			t = prometheus.CounterValue
+		}
+		help := cm.Help
+		if help == "" {
+			help = fmt.Sprintf("Computed as %s.", cm.Expr)
+		}
+		for _, index := range indexes {
+			value, ok := cm.Expr.Eval(func(name string) (float64, bool) {
+				if cv, ok := v.values[name][""]; ok {
+					return cv.value, true
+				}
+				cv, ok := v.values[name][index]
+				return cv.value, ok
+			})
+			if !ok {
+				continue
+			}
+			var labelnames, labelvalues []string
+			if indexed != "" {
+				cv := v.values[indexed][index]
+				for name, value := range indexesToLabels(cv.indexOids, cv.metric, oidToPdu, metrics) {
+					labelnames = append(labelnames, name)
+					labelvalues = append(labelvalues, value)
+				}
+			}
+			sample, err := r.newConstMetric(cm.Name, help, t, value, labelnames, labelvalues)
+			if err != nil {
+				sample = prometheus.NewInvalidMetric(prometheus.NewDesc("snmp_error", "Error calling NewConstMetric", nil, nil),
+					fmt.Errorf("error for computed metric %s with labels %v: %v", cm.Name, labelvalues, err))
+			}
+			if sample != nil {
+				samples = append(samples, sample)
+			}
+		}
+	}
+	return samples
+}
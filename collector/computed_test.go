@@ -0,0 +1,130 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gosnmp/gosnmp"
+	io_prometheus_client "github.com/prometheus/client_model/go"
+	"gopkg.in/yaml.v2"
+
+	"github.com/shatteredsilicon/snmp_exporter/config"
+)
+
+func TestComputedMetrics(t *testing.T) {
+	storageIndexes := []*config.Index{{Labelname: "hrStorageIndex", Type: "gauge"}}
+	storageLookups := []*config.Lookup{
+		{Labels: []string{"hrStorageIndex"}, Labelname: "hrStorageDescr", Oid: "1.3.6.1.2.1.25.2.3.1.3", Type: "DisplayString"},
+	}
+	metrics := []*config.Metric{
+		{Name: "hrStorageAllocationUnits", Oid: "1.3.6.1.2.1.25.2.3.1.4", Type: "gauge", Indexes: storageIndexes, Lookups: storageLookups},
+		{Name: "hrStorageSize", Oid: "1.3.6.1.2.1.25.2.3.1.5", Type: "gauge", Indexes: storageIndexes, Lookups: storageLookups},
+		{Name: "hrStorageUsed", Oid: "1.3.6.1.2.1.25.2.3.1.6", Type: "gauge", Indexes: storageIndexes, Lookups: storageLookups},
+		{Name: "hrMemorySize", Oid: "1.3.6.1.2.1.25.2.2", Type: "gauge", Scale: 1024},
+	}
+	pdus := []gosnmp.SnmpPDU{
+		{Name: ".1.3.6.1.2.1.25.2.2.0", Type: gosnmp.Integer, Value: 2048},
+		{Name: ".1.3.6.1.2.1.25.2.3.1.3.1", Type: gosnmp.OctetString, Value: []byte("/")},
+		{Name: ".1.3.6.1.2.1.25.2.3.1.3.2", Type: gosnmp.OctetString, Value: []byte("/home")},
+		{Name: ".1.3.6.1.2.1.25.2.3.1.4.1", Type: gosnmp.Integer, Value: 4096},
+		{Name: ".1.3.6.1.2.1.25.2.3.1.4.2", Type: gosnmp.Integer, Value: 512},
+		{Name: ".1.3.6.1.2.1.25.2.3.1.5.1", Type: gosnmp.Integer, Value: 100},
+		{Name: ".1.3.6.1.2.1.25.2.3.1.5.2", Type: gosnmp.Integer, Value: 10},
+		{Name: ".1.3.6.1.2.1.25.2.3.1.6.1", Type: gosnmp.Integer, Value: 25},
+		{Name: ".1.3.6.1.2.1.25.2.3.1.6.2", Type: gosnmp.Integer, Value: 5},
+		// Used but no size, which is left out.
+		{Name: ".1.3.6.1.2.1.25.2.3.1.6.3", Type: gosnmp.Integer, Value: 5},
+	}
+	var computed []*config.ComputedMetric
+	if err := yaml.UnmarshalStrict([]byte(`
+- name: storage_free_bytes
+  expr: (hrStorageSize - hrStorageUsed) * hrStorageAllocationUnits
+- name: storage_used_ratio
+  expr: hrStorageUsed / hrStorageSize
+- name: storage_of_memory_ratio
+  expr: hrStorageSize * hrStorageAllocationUnits / hrMemorySize
+- name: memory_mebibytes
+  expr: hrMemorySize / 1024 / 1024 + -1 * -2 - 2
+`), &computed); err != nil {
+		t.Fatal(err)
+	}
+
+	oidToPdu := make(map[string]gosnmp.SnmpPDU, len(pdus))
+	for _, pdu := range pdus {
+		oidToPdu[pdu.Name[1:]] = pdu
+	}
+	metricTree := buildMetricTree(metrics)
+	values := newComputedValues(computed)
+	for _, pdu := range pdus {
+		pdu := pdu
+		head := metricTree
+		oidList := oidToList(pdu.Name[1:])
+		for i, o := range oidList {
+			var ok bool
+			if head, ok = head.children[o]; !ok {
+				break
+			}
+			if head.metric != nil {
+				values.add(head.metric, oidList[i+1:], &pdu)
+				break
+			}
+		}
+	}
+
+	var got []string
+	for _, sample := range values.samples(oidToPdu, relabeler{}, Metrics{}) {
+		m := &io_prometheus_client.Metric{}
+		if err := sample.Write(m); err != nil {
+			t.Fatal(err)
+		}
+		name := fqNameRE.FindStringSubmatch(sample.Desc().String())[1]
+		labels := make([]string, 0, len(m.Label))
+		for _, l := range m.Label {
+			labels = append(labels, l.GetName()+"="+l.GetValue())
+		}
+		got = append(got, name+"{"+strings.Join(labels, ",")+"} "+strconv.FormatFloat(m.GetGauge().GetValue(), 'g', -1, 64))
+	}
+	sort.Strings(got)
+	want := []string{
+		"memory_mebibytes{} 2",
+		"storage_free_bytes{hrStorageDescr=/,hrStorageIndex=1} 307200",
+		"storage_free_bytes{hrStorageDescr=/home,hrStorageIndex=2} 2560",
+		"storage_of_memory_ratio{hrStorageDescr=/,hrStorageIndex=1} 0.1953125",
+		"storage_of_memory_ratio{hrStorageDescr=/home,hrStorageIndex=2} 0.00244140625",
+		"storage_used_ratio{hrStorageDescr=/,hrStorageIndex=1} 0.25",
+		"storage_used_ratio{hrStorageDescr=/home,hrStorageIndex=2} 0.5",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("want samples:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestComputedMetricsExprErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"a +",
+		"(a + b",
+		"a b",
+		"a % b",
+		"1..2",
+	} {
+		if _, err := config.ParseExpr(expr); err == nil {
+			t.Errorf("want error for %q", expr)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// Reasons a get or walk failed, as reported in snmp_scrape_error_reason.
+const (
+	reasonTimeout = "timeout"
+	// The scrape was canceled, e.g. because the client went away.
+	reasonCanceled          = "canceled"
+	reasonAuthFailure       = "auth_failure"
+	reasonNoSuchName        = "no_such_name"
+	reasonTooBig            = "too_big"
+	reasonGenErr            = "gen_err"
+	reasonConnectionRefused = "connection_refused"
+	// Any other error status reported by the target.
+	reasonErrorStatus = "error_status"
+	reasonOther       = "other"
+)
+
+// Names of the error statuses as in RFC 3416.
+var errorStatusNames = map[gosnmp.SNMPError]string{
+	gosnmp.TooBig:              "tooBig",
+	gosnmp.NoSuchName:          "noSuchName",
+	gosnmp.BadValue:            "badValue",
+	gosnmp.ReadOnly:            "readOnly",
+	gosnmp.GenErr:              "genErr",
+	gosnmp.NoAccess:            "noAccess",
+	gosnmp.WrongType:           "wrongType",
+	gosnmp.WrongLength:         "wrongLength",
+	gosnmp.WrongEncoding:       "wrongEncoding",
+	gosnmp.WrongValue:          "wrongValue",
+	gosnmp.NoCreation:          "noCreation",
+	gosnmp.InconsistentValue:   "inconsistentValue",
+	gosnmp.ResourceUnavailable: "resourceUnavailable",
+	gosnmp.CommitFailed:        "commitFailed",
+	gosnmp.UndoFailed:          "undoFailed",
+	gosnmp.AuthorizationError:  "authorizationError",
+	gosnmp.NotWritable:         "notWritable",
+	gosnmp.InconsistentName:    "inconsistentName",
+}
+
+// Errors returned by gosnmp for SNMPv3 report PDUs that mean the
+// credentials or security level were rejected.
+var authErrors = []error{
+	gosnmp.ErrUnknownUsername,
+	gosnmp.ErrWrongDigest,
+	gosnmp.ErrUnknownSecurityLevel,
+	gosnmp.ErrDecryption,
+	gosnmp.ErrUnknownSecurityModels,
+	gosnmp.ErrNotInTimeWindow,
+	gosnmp.ErrUnknownEngineID,
+}
+
+// errorStatusError is an error status in the response to a get or walk.
+type errorStatusError struct {
+	status gosnmp.SNMPError
+	// The OID the target reported the error for.
+	oid string
+}
+
+func (e *errorStatusError) Error() string {
+	name, ok := errorStatusNames[e.status]
+	if !ok {
+		name = fmt.Sprintf("error status %d", e.status)
+	}
+	return fmt.Sprintf("%s reported by target for OID %s", name, e.oid)
+}
+
+// newErrorStatusError returns the error for the error status of a
+// response to a request for oids.
+func newErrorStatusError(packet *gosnmp.SnmpPacket, oids []string) *errorStatusError {
+	oid := strings.Join(oids, ",")
+	// The error index is 1-based, 0 if no OID is to blame.
+	if i := int(packet.ErrorIndex); i > 0 && i <= len(oids) {
+		oid = oids[i-1]
+	}
+	return &errorStatusError{status: packet.Error, oid: oid}
+}
+
+// errorReason classifies the error of a failed get or walk.
+func errorReason(err error) string {
+	var statusErr *errorStatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.status {
+		case gosnmp.NoSuchName:
+			return reasonNoSuchName
+		case gosnmp.TooBig:
+			return reasonTooBig
+		case gosnmp.GenErr:
+			return reasonGenErr
+		default:
+			return reasonErrorStatus
+		}
+	}
+	for _, authErr := range authErrors {
+		if errors.Is(err, authErr) {
+			return reasonAuthFailure
+		}
+	}
+	// Responses failing authentication are dropped by gosnmp with a plain error.
+	if strings.Contains(err.Error(), "not authentic") {
+		return reasonAuthFailure
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return reasonConnectionRefused
+	}
+	if errors.Is(err, context.Canceled) {
+		return reasonCanceled
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) ||
+		(errors.As(err, &netErr) && netErr.Timeout()) || strings.Contains(err.Error(), "request timeout") {
+		return reasonTimeout
+	}
+	return reasonOther
+}
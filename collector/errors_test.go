@@ -0,0 +1,173 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/gosnmp/gosnmp"
+	"github.com/prometheus/client_golang/prometheus"
+	io_prometheus_client "github.com/prometheus/client_model/go"
+
+	"github.com/shatteredsilicon/snmp_exporter/config"
+	"github.com/shatteredsilicon/snmp_exporter/snmpsim"
+)
+
+func TestErrorStatusError(t *testing.T) {
+	packet := &gosnmp.SnmpPacket{Error: gosnmp.GenErr, ErrorIndex: 2}
+	err := newErrorStatusError(packet, []string{"1.3.6.1.2.1.1.3.0", "1.3.6.1.2.1.1.5.0"})
+	if want := "genErr reported by target for OID 1.3.6.1.2.1.1.5.0"; err.Error() != want {
+		t.Errorf("want %q, got %q", want, err.Error())
+	}
+	packet = &gosnmp.SnmpPacket{Error: gosnmp.SNMPError(42)}
+	err = newErrorStatusError(packet, []string{"1.3.6.1.2.1.1.3.0", "1.3.6.1.2.1.1.5.0"})
+	if want := "error status 42 reported by target for OID 1.3.6.1.2.1.1.3.0,1.3.6.1.2.1.1.5.0"; err.Error() != want {
+		t.Errorf("want %q, got %q", want, err.Error())
+	}
+}
+
+func TestScrapeTargetErrorReason(t *testing.T) {
+	agent := startTestAgent(t)
+	if err := agent.AddUser(snmpsim.User{Name: "user", AuthProtocol: gosnmp.SHA, AuthPassword: "authpassword"}); err != nil {
+		t.Fatal(err)
+	}
+	closed, err := snmpsim.Load("../testdata/device.snmprec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := closed.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	refused := closed.Addr()
+	closed.Close()
+
+	retries := 0
+	module := &config.Module{
+		Get:  []string{"1.3.6.1.2.1.1.3.0"},
+		Walk: []string{"1.3.6.1.2.1.2.2.1.10"},
+		WalkParams: config.WalkParams{
+			MaxRepetitions: 25,
+			Retries:        &retries,
+			Timeout:        200 * time.Millisecond,
+		},
+	}
+	v2 := &config.Auth{Community: "public", SecurityLevel: "noAuthNoPriv", Version: 2}
+
+	tests := []struct {
+		name   string
+		target string
+		auth   *config.Auth
+		setup  func()
+		reason string
+		oid    string
+	}{
+		{
+			name:   "timeout",
+			setup:  func() { agent.Drop(-1) },
+			reason: reasonTimeout,
+			oid:    "1.3.6.1.2.1.1.3.0",
+		},
+		{
+			name:   "auth failure",
+			auth:   &config.Auth{Username: "user", SecurityLevel: "authNoPriv", AuthProtocol: "SHA", Password: "wrongpassword", Version: 3},
+			reason: reasonAuthFailure,
+		},
+		{
+			name:   "genErr",
+			setup:  func() { agent.SetError("1.3.6.1.2.1.1.3", gosnmp.GenErr) },
+			reason: reasonGenErr,
+			oid:    "1.3.6.1.2.1.1.3.0",
+		},
+		{
+			name:   "noSuchName",
+			setup:  func() { agent.SetError("1.3.6.1.2.1.1.3", gosnmp.NoSuchName) },
+			reason: reasonNoSuchName,
+			oid:    "1.3.6.1.2.1.1.3.0",
+		},
+		{
+			name:   "tooBig",
+			setup:  func() { agent.SetError("1.3.6.1.2.1.1.3", gosnmp.TooBig) },
+			reason: reasonTooBig,
+			oid:    "1.3.6.1.2.1.1.3.0",
+		},
+		{
+			name:   "connection refused",
+			target: refused,
+			reason: reasonConnectionRefused,
+		},
+	}
+	for _, test := range tests {
+		agent.Drop(0)
+		agent.SetError("1.3.6.1.2.1.1.3", gosnmp.NoError)
+		if test.setup != nil {
+			test.setup()
+		}
+		target, auth := agent.Addr(), v2
+		if test.target != "" {
+			target = test.target
+		}
+		if test.auth != nil {
+			auth = test.auth
+		}
+		_, err := ScrapeTarget(context.Background(), target, auth, module, log.NewNopLogger(), testMetrics())
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+			continue
+		}
+		if got := errorReason(err); got != test.reason {
+			t.Errorf("%s: want reason %s, got %s: %s", test.name, test.reason, got, err)
+		}
+		if !strings.Contains(err.Error(), test.oid) {
+			t.Errorf("%s: want OID %s in error, got %s", test.name, test.oid, err)
+		}
+	}
+}
+
+func TestCollectCanceled(t *testing.T) {
+	agent := startTestAgent(t)
+	t.Cleanup(ResetAuthCache)
+	auth := &config.Auth{Community: "public", SecurityLevel: "noAuthNoPriv", Version: 2}
+	auths := []*NamedAuth{NewNamedAuth("first", auth), NewNamedAuth("second", auth)}
+	retries := 0
+	module := &config.Module{
+		Get:        []string{"1.3.6.1.2.1.1.3.0"},
+		WalkParams: config.WalkParams{Timeout: time.Second, Retries: &retries},
+	}
+	key := authCacheKey(agent.Addr(), auths)
+	selectedAuths.put(key, "second", time.Now().Add(time.Minute), false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c := New(ctx, agent.Addr(), auths, []*NamedModule{NewNamedModule("canceled", module)}, log.NewNopLogger(), testMetrics(), 1)
+	ch := make(chan prometheus.Metric, 10)
+	if c.collect(ch, c.modules[0]) {
+		t.Fatal("expected a canceled scrape to fail")
+	}
+	close(ch)
+	if len(ch) != 1 {
+		t.Fatalf("expected a single snmp_error, got %d samples", len(ch))
+	}
+	var out io_prometheus_client.Metric
+	if err := (<-ch).Write(&out); err == nil || !strings.Contains(err.Error(), reasonCanceled+": ") {
+		t.Errorf("expected the scrape to fail as canceled, got %v", err)
+	}
+	// The client going away isn't a reason to look for another auth.
+	if name, ok := selectedAuths.get(key, time.Now()); !ok || name != "second" {
+		t.Errorf("expected the selected auth to be kept, got %q", name)
+	}
+}
@@ -9,15 +9,11 @@ const (
 	nodeCPUAverageHelp = "The percentage of CPU utilization."
 )
 
-func (c *Collector) collecSSMCPUMetrics() ([]prometheus.Metric, error) {
+func (e *ssmRecordEntry) collecSSMCPUMetrics() ([]prometheus.Metric, error) {
 	samples := []prometheus.Metric{}
 
-	history, ok := ssmMetricRecords.history[c.target]
-	if !ok {
-		return samples, nil
-	}
-	current, ok := ssmMetricRecords.current[c.target]
-	if !ok {
+	history, current := e.history, e.current
+	if history == nil || current == nil {
 		return samples, nil
 	}
 	if current.hrSystemDate <= history.hrSystemDate {
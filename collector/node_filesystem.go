@@ -21,82 +21,75 @@ const (
 	filesystemFreeHelp = "The free size of the filesystem"
 )
 
-func init() {
-	ssmMetrics["hrStorageSize"] = ssmMetric{
-		Type: config.MetricTypeGauge,
-		NewConstMetric: func(
-			metric *config.Metric,
-			t prometheus.ValueType,
-			value float64,
-			labelNames, labelValues []string,
-			constLabels prometheus.Labels,
-		) ([]prometheus.Metric, error) {
-			var unit, used float64
-			var typ, descr string
-			var err error
+// filesystemConstMetrics converts the hrStorage table to memory and
+// filesystem series.
+func filesystemConstMetrics(
+	metric *config.Metric,
+	t prometheus.ValueType,
+	value float64,
+	labelNames, labelValues []string,
+	r relabeler,
+) ([]prometheus.Metric, error) {
+	var unit, used float64
+	var typ, descr string
+	var err error
 
-			metricLNs := []string{"device", "fstype", "mountpoint"}
-			metricLVs := []string{"", "unknown"}
+	metricLNs := []string{"device", "fstype", "mountpoint"}
+	metricLVs := []string{"", "unknown"}
 
-			for i, labelName := range labelNames {
-				switch labelName {
-				case "hrStorageAllocationUnits":
-					unit, err = strconv.ParseFloat(labelValues[i], 64)
-					if err != nil {
-						return nil, fmt.Errorf("failed to parse hrStorageAllocationUnits: %s", err.Error())
-					}
-				case "hrStorageType":
-					parts := strings.Split(labelValues[i], ".")
-					typ = parts[len(parts)-1]
-				case "hrStorageDescr":
-					descr = labelValues[i]
-					labelIndex := strings.Index(descr, " Label:")
-					if labelIndex != -1 {
-						descr = descr[:labelIndex]
-					}
-					metricLVs = append(metricLVs, descr)
-				case "hrStorageUsed":
-					used, err = strconv.ParseFloat(labelValues[i], 64)
-					if err != nil {
-						return nil, fmt.Errorf("failed to parse hrStorageUsed: %s", err.Error())
-					}
-				}
+	for i, labelName := range labelNames {
+		switch labelName {
+		case "hrStorageAllocationUnits":
+			unit, err = strconv.ParseFloat(labelValues[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse hrStorageAllocationUnits: %s", err.Error())
 			}
-
-			samples := []prometheus.Metric{}
-			if typ == hrStorageVirtualMemory && strings.ToLower(strings.TrimSpace(descr)) == "virtual memory" {
-				sample, err := prometheus.NewConstMetric(prometheus.NewDesc(memVirtualName, memVirtualHelp, nil, nil),
-					t, unit*value)
-				if err != nil {
-					return samples, err
-				}
-				samples = append(samples, sample)
+		case "hrStorageType":
+			parts := strings.Split(labelValues[i], ".")
+			typ = parts[len(parts)-1]
+		case "hrStorageDescr":
+			descr = labelValues[i]
+			labelIndex := strings.Index(descr, " Label:")
+			if labelIndex != -1 {
+				descr = descr[:labelIndex]
+			}
+			metricLVs = append(metricLVs, descr)
+		case "hrStorageUsed":
+			used, err = strconv.ParseFloat(labelValues[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse hrStorageUsed: %s", err.Error())
 			}
+		}
+	}
 
-			if typ == hrStorageFixedDisk {
-				sample, err := prometheus.NewConstMetric(prometheus.NewDesc(filesystemSizeName, removeOidSuffix(metric.Help), metricLNs, nil),
-					t, value*unit, metricLVs...)
-				if err != nil {
-					return samples, err
-				}
-				samples = append(samples, sample)
+	samples := []prometheus.Metric{}
+	if typ == hrStorageVirtualMemory && strings.ToLower(strings.TrimSpace(descr)) == "virtual memory" {
+		sample, err := r.newConstMetric(memVirtualName, memVirtualHelp, t, unit*value, nil, nil)
+		if err != nil {
+			return samples, err
+		}
+		samples = append(samples, sample)
+	}
 
-				sample, err = prometheus.NewConstMetric(prometheus.NewDesc(filesystemUsedName, filesystemUsedHelp, metricLNs, nil),
-					t, used*unit, metricLVs...)
-				if err != nil {
-					return samples, err
-				}
-				samples = append(samples, sample)
+	if typ == hrStorageFixedDisk {
+		sample, err := r.newConstMetric(filesystemSizeName, removeOidSuffix(metric.Help), t, value*unit, metricLNs, metricLVs)
+		if err != nil {
+			return samples, err
+		}
+		samples = append(samples, sample)
 
-				sample, err = prometheus.NewConstMetric(prometheus.NewDesc(filesystemFreeName, filesystemFreeHelp, metricLNs, nil),
-					t, (value-used)*unit, metricLVs...)
-				if err != nil {
-					return samples, err
-				}
-				samples = append(samples, sample)
-			}
+		sample, err = r.newConstMetric(filesystemUsedName, filesystemUsedHelp, t, used*unit, metricLNs, metricLVs)
+		if err != nil {
+			return samples, err
+		}
+		samples = append(samples, sample)
 
-			return samples, nil
-		},
+		sample, err = r.newConstMetric(filesystemFreeName, filesystemFreeHelp, t, (value-used)*unit, metricLNs, metricLVs)
+		if err != nil {
+			return samples, err
+		}
+		samples = append(samples, sample)
 	}
+
+	return samples, nil
 }
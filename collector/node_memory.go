@@ -2,7 +2,6 @@ package collector
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/shatteredsilicon/snmp_exporter/config"
 )
 
 const (
@@ -14,57 +13,11 @@ const (
 	memVirtualHelp   = "The amount of virtual memory"
 )
 
-func init() {
-	ssmMetrics["memTotalSwap"] = ssmMetric{
-		Type:        config.MetricTypeGauge,
-		RenameTo:    "node_memory_SwapTotal",
-		HandleValue: handleMemoryValue,
-	}
-	ssmMetrics["memAvailSwap"] = ssmMetric{
-		Type:        config.MetricTypeGauge,
-		RenameTo:    "node_memory_SwapFree",
-		HandleValue: handleMemoryValue,
-	}
-	ssmMetrics["hrMemorySize"] = ssmMetric{
-		Type:        config.MetricTypeGauge,
-		RenameTo:    "node_memory_MemTotal",
-		HandleValue: handleMemoryValue,
-	}
-	ssmMetrics["memAvailReal"] = ssmMetric{
-		Type:        config.MetricTypeGauge,
-		RenameTo:    "node_memory_MemFree",
-		HandleValue: handleMemoryValue,
-	}
-	ssmMetrics["memShared"] = ssmMetric{
-		Type:        config.MetricTypeGauge,
-		RenameTo:    "node_memory_Shmem",
-		HandleValue: handleMemoryValue,
-	}
-	ssmMetrics["memBuffer"] = ssmMetric{
-		Type:        config.MetricTypeGauge,
-		RenameTo:    "node_memory_Buffers",
-		HandleValue: handleMemoryValue,
-	}
-	ssmMetrics["memCached"] = ssmMetric{
-		Type:        config.MetricTypeGauge,
-		RenameTo:    "node_memory_Cached",
-		HandleValue: handleMemoryValue,
-	}
-	ssmMetrics["memSysAvail"] = ssmMetric{
-		Type:        config.MetricTypeCounter,
-		RenameTo:    "node_memory_MemAvailable",
-		HandleValue: handleMemoryValue,
-	}
-}
-
-// handleMemoryValue converts memory unit from 'KB' to 'B'
-func handleMemoryValue(value float64) float64 { return value * 1024 }
-
-func (c *Collector) collectSSMMemoryMetrics() ([]prometheus.Metric, error) {
+func (e *ssmRecordEntry) collectSSMMemoryMetrics() ([]prometheus.Metric, error) {
 	samples := []prometheus.Metric{}
 
-	current, ok := ssmMetricRecords.current[c.target]
-	if !ok {
+	current := e.current
+	if current == nil {
 		return samples, nil
 	}
 
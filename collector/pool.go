@@ -0,0 +1,183 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/gosnmp/gosnmp"
+
+	"github.com/shatteredsilicon/snmp_exporter/config"
+)
+
+var (
+	sessionPoolSize        = kingpin.Flag("snmp.session-pool-size", "Maximum number of idle SNMP sessions kept for reuse across scrapes. 0 disables session reuse.").Default("0").Int()
+	sessionPoolIdleTimeout = kingpin.Flag("snmp.session-pool-idle-timeout", "How long an idle SNMP session is kept for reuse before it's closed.").Default("1m").Duration()
+)
+
+// sessionKey identifies sessions that can be used interchangeably.
+type sessionKey struct {
+	target                  string
+	auth                    *config.Auth
+	maxRepetitions          uint32
+	retries                 int
+	timeout                 time.Duration
+	useUnconnectedUDPSocket bool
+	allowNonIncreasingOIDs  bool
+	// The generation of the session pool when the key was made. Sessions
+	// of scrapes that started before the pool was reset aren't pooled.
+	generation uint64
+}
+
+func newSessionKey(target string, auth *config.Auth, params config.WalkParams) sessionKey {
+	key := sessionKey{
+		generation:              sessions.currentGeneration(),
+		target:                  target,
+		auth:                    auth,
+		maxRepetitions:          params.MaxRepetitions,
+		timeout:                 params.Timeout,
+		useUnconnectedUDPSocket: config.Enabled(params.UseUnconnectedUDPSocket),
+		allowNonIncreasingOIDs:  config.Enabled(params.AllowNonIncreasingOIDs),
+	}
+	if params.Retries != nil {
+		key.retries = *params.Retries
+	}
+	return key
+}
+
+type idleSession struct {
+	snmp      *gosnmp.GoSNMP
+	idleSince time.Time
+}
+
+// sessionPool keeps connected SNMP sessions between scrapes, so that
+// connection setup and SNMPv3 engine discovery don't happen every time.
+type sessionPool struct {
+	mu          sync.Mutex
+	idle        map[sessionKey][]idleSession
+	count       int
+	generation  uint64
+	size        func() int
+	idleTimeout func() time.Duration
+}
+
+func newSessionPool(size func() int, idleTimeout func() time.Duration) *sessionPool {
+	return &sessionPool{
+		idle:        make(map[sessionKey][]idleSession),
+		size:        size,
+		idleTimeout: idleTimeout,
+	}
+}
+
+var sessions = newSessionPool(
+	func() int { return *sessionPoolSize },
+	func() time.Duration { return *sessionPoolIdleTimeout },
+)
+
+// get returns an idle session for the key, or nil if there is none.
+func (p *sessionPool) get(key sessionKey, metrics Metrics) *gosnmp.GoSNMP {
+	if p.size() <= 0 {
+		return nil
+	}
+	p.mu.Lock()
+	p.expireLocked(time.Now())
+	var snmp *gosnmp.GoSNMP
+	if idle := p.idle[key]; len(idle) > 0 {
+		snmp = idle[len(idle)-1].snmp
+		p.idle[key] = idle[:len(idle)-1]
+		if len(p.idle[key]) == 0 {
+			delete(p.idle, key)
+		}
+		p.count--
+	}
+	p.mu.Unlock()
+
+	if snmp != nil {
+		metrics.SNMPSessionPoolHits.Inc()
+	} else {
+		metrics.SNMPSessionPoolMisses.Inc()
+	}
+	return snmp
+}
+
+// put hands a session back to the pool, closing it if the pool is full or
+// has been reset since the key was made.
+func (p *sessionPool) put(key sessionKey, snmp *gosnmp.GoSNMP) {
+	snmp.Context = nil
+	snmp.PreSend = nil
+	snmp.OnSent = nil
+	snmp.OnRecv = nil
+	snmp.OnRetry = nil
+
+	now := time.Now()
+	p.mu.Lock()
+	p.expireLocked(now)
+	if p.count >= p.size() || key.generation != p.generation {
+		p.mu.Unlock()
+		snmp.Conn.Close()
+		return
+	}
+	p.idle[key] = append(p.idle[key], idleSession{snmp: snmp, idleSince: now})
+	p.count++
+	p.mu.Unlock()
+}
+
+func (p *sessionPool) expireLocked(now time.Time) {
+	timeout := p.idleTimeout()
+	for key, idle := range p.idle {
+		kept := idle[:0]
+		for _, s := range idle {
+			if now.Sub(s.idleSince) > timeout {
+				s.snmp.Conn.Close()
+				p.count--
+				continue
+			}
+			kept = append(kept, s)
+		}
+		if len(kept) == 0 {
+			delete(p.idle, key)
+		} else {
+			p.idle[key] = kept
+		}
+	}
+}
+
+func (p *sessionPool) currentGeneration() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.generation
+}
+
+// reset closes all idle sessions, and starts a new generation so that
+// sessions in use aren't handed back.
+func (p *sessionPool) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.generation++
+	for _, idle := range p.idle {
+		for _, s := range idle {
+			s.snmp.Conn.Close()
+		}
+	}
+	p.idle = make(map[sessionKey][]idleSession)
+	p.count = 0
+}
+
+// ResetSessionPool closes all idle SNMP sessions. It must be called when
+// the configuration is reloaded, so that no session outlives its auth.
+func ResetSessionPool() {
+	sessions.reset()
+}
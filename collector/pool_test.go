@@ -0,0 +1,132 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/shatteredsilicon/snmp_exporter/config"
+)
+
+func testPoolMetrics() Metrics {
+	return Metrics{
+		SNMPSessionPoolHits:   prometheus.NewCounter(prometheus.CounterOpts{Name: "hits"}),
+		SNMPSessionPoolMisses: prometheus.NewCounter(prometheus.CounterOpts{Name: "misses"}),
+	}
+}
+
+func testSession() *gosnmp.GoSNMP {
+	conn, _ := net.Pipe()
+	return &gosnmp.GoSNMP{Conn: conn}
+}
+
+func TestSessionPool(t *testing.T) {
+	metrics := testPoolMetrics()
+	pool := newSessionPool(func() int { return 2 }, func() time.Duration { return time.Minute })
+	auth := &config.Auth{Version: 2}
+	key := newSessionKey("192.0.0.8", auth, config.DefaultWalkParams)
+	otherKey := newSessionKey("192.0.0.9", auth, config.DefaultWalkParams)
+
+	if s := pool.get(key, metrics); s != nil {
+		t.Fatal("expected an empty pool")
+	}
+	s := testSession()
+	s.OnSent = func(*gosnmp.GoSNMP) {}
+	pool.put(key, s)
+	if s.OnSent != nil {
+		t.Error("expected callbacks to be cleared when returned to the pool")
+	}
+	if got := pool.get(otherKey, metrics); got != nil {
+		t.Error("expected no session for a different key")
+	}
+	if got := pool.get(key, metrics); got != s {
+		t.Errorf("expected pooled session back, got %v", got)
+	}
+	if hits := testutil.ToFloat64(metrics.SNMPSessionPoolHits); hits != 1 {
+		t.Errorf("expected 1 hit, got %v", hits)
+	}
+	if misses := testutil.ToFloat64(metrics.SNMPSessionPoolMisses); misses != 2 {
+		t.Errorf("expected 2 misses, got %v", misses)
+	}
+
+	// The pool never holds more than its size.
+	for i := 0; i < 3; i++ {
+		pool.put(key, testSession())
+	}
+	if pool.count != 2 {
+		t.Errorf("expected 2 idle sessions, got %d", pool.count)
+	}
+
+	pool.reset()
+	if pool.count != 0 || len(pool.idle) != 0 {
+		t.Errorf("expected reset to empty the pool, got %d sessions", pool.count)
+	}
+}
+
+func TestSessionPoolReset(t *testing.T) {
+	metrics := testPoolMetrics()
+	pool := newSessionPool(func() int { return 2 }, func() time.Duration { return time.Minute })
+	key := newSessionKey("192.0.0.8", &config.Auth{Version: 2}, config.DefaultWalkParams)
+	key.generation = pool.currentGeneration()
+
+	// A scrape still running with the old configuration hands its session
+	// back after the reload.
+	pool.reset()
+	pool.put(key, testSession())
+	if pool.count != 0 || len(pool.idle) != 0 {
+		t.Errorf("expected a session from before the reset to be closed, got %d sessions", pool.count)
+	}
+
+	key.generation = pool.currentGeneration()
+	s := testSession()
+	pool.put(key, s)
+	if got := pool.get(key, metrics); got != s {
+		t.Errorf("expected the session of the current generation back, got %v", got)
+	}
+}
+
+func TestSessionPoolIdleTimeout(t *testing.T) {
+	metrics := testPoolMetrics()
+	pool := newSessionPool(func() int { return 2 }, func() time.Duration { return time.Minute })
+	key := newSessionKey("192.0.0.8", &config.Auth{Version: 2}, config.DefaultWalkParams)
+
+	pool.put(key, testSession())
+	pool.idle[key][0].idleSince = time.Now().Add(-2 * time.Minute)
+	if got := pool.get(key, metrics); got != nil {
+		t.Error("expected expired session not to be reused")
+	}
+	if pool.count != 0 {
+		t.Errorf("expected expired session to be removed, got %d sessions", pool.count)
+	}
+}
+
+func TestSessionPoolDisabled(t *testing.T) {
+	metrics := testPoolMetrics()
+	pool := newSessionPool(func() int { return 0 }, func() time.Duration { return time.Minute })
+	key := newSessionKey("192.0.0.8", &config.Auth{Version: 2}, config.DefaultWalkParams)
+
+	pool.put(key, testSession())
+	if got := pool.get(key, metrics); got != nil {
+		t.Error("expected no reuse with a disabled pool")
+	}
+	if misses := testutil.ToFloat64(metrics.SNMPSessionPoolMisses); misses != 0 {
+		t.Errorf("expected no misses to be counted with a disabled pool, got %v", misses)
+	}
+}
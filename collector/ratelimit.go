@@ -0,0 +1,83 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+var targetPacketRate = kingpin.Flag("snmp.target-packets-per-second", "Maximum number of SNMP packets per second sent to a single target, across all scrapes. 0 means no limit.").Default("0").Float64()
+
+// packetPacer spaces out the packets sent to each target. Each packet
+// reserves the next free slot of its target, and the sender waits for
+// that slot before sending again.
+type packetPacer struct {
+	mu   sync.Mutex
+	next map[string]time.Time
+	rate func() float64
+}
+
+func newPacketPacer(rate func() float64) *packetPacer {
+	return &packetPacer{
+		next: make(map[string]time.Time),
+		rate: rate,
+	}
+}
+
+var pacer = newPacketPacer(func() float64 { return *targetPacketRate })
+
+// reserve books a slot for a packet to the target and returns how long
+// to wait before it's due.
+func (p *packetPacer) reserve(target string, now time.Time) time.Duration {
+	rate := p.rate()
+	if rate <= 0 {
+		return 0
+	}
+	interval := time.Duration(float64(time.Second) / rate)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Forget targets that have been idle for a while.
+	for t, next := range p.next {
+		if now.Sub(next) > time.Minute {
+			delete(p.next, t)
+		}
+	}
+	slot := p.next[target]
+	if slot.Before(now) {
+		slot = now
+	}
+	p.next[target] = slot.Add(interval)
+	return slot.Sub(now)
+}
+
+// wait blocks until the next packet to the target may be sent, or the
+// context is done. It returns whether it waited.
+func (p *packetPacer) wait(ctx context.Context, target string) bool {
+	d := p.reserve(target, time.Now())
+	if d <= 0 {
+		return false
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+	return true
+}
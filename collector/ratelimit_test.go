@@ -0,0 +1,92 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/shatteredsilicon/snmp_exporter/config"
+)
+
+func TestPacketPacer(t *testing.T) {
+	rate := 0.0
+	p := newPacketPacer(func() float64 { return rate })
+	now := time.Now()
+
+	if d := p.reserve("192.0.0.8", now); d != 0 {
+		t.Errorf("Expected no wait without a rate, got %s", d)
+	}
+
+	rate = 10
+	for i, want := range []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond} {
+		if d := p.reserve("192.0.0.8", now); d != want {
+			t.Errorf("Packet %d: expected wait of %s, got %s", i, want, d)
+		}
+	}
+	if d := p.reserve("192.0.0.9", now); d != 0 {
+		t.Errorf("Expected targets to be paced independently, got %s", d)
+	}
+	if d := p.reserve("192.0.0.8", now.Add(time.Second)); d != 0 {
+		t.Errorf("Expected no wait once the target has been idle, got %s", d)
+	}
+
+	p.reserve("192.0.0.10", now.Add(5*time.Minute))
+	if _, ok := p.next["192.0.0.8"]; ok {
+		t.Error("Expected idle target to be forgotten")
+	}
+}
+
+func TestPacketPacerScrape(t *testing.T) {
+	agent := startTestAgent(t)
+	rate := *targetPacketRate
+	*targetPacketRate = 5
+	t.Cleanup(func() { *targetPacketRate = rate })
+
+	// Each packet waits 200ms for its slot, longer than the timeout, which
+	// only starts once it's sent.
+	retries := 0
+	module := &config.Module{
+		Walk: []string{"1.3.6.1.2.1.2.2.1.2"},
+		WalkParams: config.WalkParams{
+			MaxRepetitions: 1,
+			Retries:        &retries,
+			Timeout:        100 * time.Millisecond,
+		},
+	}
+	auth := &config.Auth{Community: "public", SecurityLevel: "noAuthNoPriv", Version: 2}
+	metrics := testMetrics()
+	start := time.Now()
+	results, err := ScrapeTarget(context.Background(), agent.Addr(), auth, module, log.NewNopLogger(), metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.retries != 0 {
+		t.Errorf("Expected no retries, got %d", results.retries)
+	}
+	if min := time.Duration(results.packets-1) * 200 * time.Millisecond; time.Since(start) < min {
+		t.Errorf("Expected %d packets to take at least %s, took %s", results.packets, min, time.Since(start))
+	}
+	m := &dto.Metric{}
+	if err := metrics.SNMPDuration.Write(m); err != nil {
+		t.Fatal(err)
+	}
+	if sum := m.GetHistogram().GetSampleSum(); sum > float64(results.packets)*0.1 {
+		t.Errorf("Expected packet durations not to include the pacing, got %fs for %d packets", sum, results.packets)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/gosnmp/gosnmp"
+
+	"github.com/shatteredsilicon/snmp_exporter/config"
+	"github.com/shatteredsilicon/snmp_exporter/snmprec"
+)
+
+var recordDir = kingpin.Flag("snmp.record-dir", "Directory to record the PDUs of every scrape to, in one snmprec file per target. replay:// targets are served from the files in this directory.").Default("").String()
+
+// ReplayScheme is the target prefix for scrapes served from recorded PDUs
+// instead of the network.
+const ReplayScheme = "replay://"
+
+// recordPath returns the snmprec file of a target in dir.
+func recordPath(dir, target string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, target)
+	return filepath.Join(dir, name+".snmprec")
+}
+
+var recordMtx sync.Mutex
+
+// recordPdus merges the PDUs into the snmprec file of the target, so that
+// scrapes of several modules end up in the same file.
+func recordPdus(dir, target string, pdus []gosnmp.SnmpPDU) error {
+	recordMtx.Lock()
+	defer recordMtx.Unlock()
+
+	path := recordPath(dir, target)
+	existing, err := snmprec.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	byOid := make(map[string]gosnmp.SnmpPDU, len(existing)+len(pdus))
+	for _, pdu := range existing {
+		byOid[pdu.Name] = pdu
+	}
+	for _, pdu := range pdus {
+		if _, err := snmprec.FormatLine(pdu); err != nil {
+			continue
+		}
+		byOid[pdu.Name] = pdu
+	}
+	merged := make([]gosnmp.SnmpPDU, 0, len(byOid))
+	for _, pdu := range byOid {
+		merged = append(merged, pdu)
+	}
+	snmprec.Sort(merged)
+	return snmprec.WriteFile(path, merged)
+}
+
+// walkPdus returns the PDUs in the subtree, in OID order.
+func walkPdus(pdus []gosnmp.SnmpPDU, subtree string) []gosnmp.SnmpPDU {
+	prefix := "." + strings.TrimPrefix(subtree, ".")
+	result := []gosnmp.SnmpPDU{}
+	for _, pdu := range pdus {
+		if pdu.Name == prefix || strings.HasPrefix(pdu.Name, prefix+".") {
+			result = append(result, pdu)
+		}
+	}
+	return result
+}
+
+// replayTarget serves a scrape from the recorded PDUs of the target,
+// applying the gets, walks and filters of the module like a real scrape.
+func replayTarget(target string, module *config.Module, logger log.Logger, metrics Metrics) (ScrapeResults, error) {
+	results := ScrapeResults{}
+	if *recordDir == "" {
+		return results, fmt.Errorf("replay of target %s requires --snmp.record-dir", target)
+	}
+	pdus, err := snmprec.ReadFile(recordPath(*recordDir, target))
+	if err != nil {
+		return results, fmt.Errorf("error replaying target %s: %w", target, err)
+	}
+	byOid := make(map[string]gosnmp.SnmpPDU, len(pdus))
+	for _, pdu := range pdus {
+		byOid[pdu.Name] = pdu
+	}
+
+	var allowedList []string
+	newGet := module.Get
+	newWalk := module.Walk
+	for _, filter := range module.Filters {
+		allowedList = filterAllowedIndices(logger, filter, walkPdus(pdus, filter.Oid), allowedList, metrics)
+		newWalk = updateWalkConfig(newWalk, filter, logger)
+		newCfg := updateGetConfig(newGet, filter, logger)
+		newGet = addAllowedIndices(filter, allowedList, logger, newCfg)
+	}
+
+	for _, oid := range newGet {
+		pdu, ok := byOid["."+strings.TrimPrefix(oid, ".")]
+		if !ok {
+			level.Debug(logger).Log("msg", "OID not supported by target", "oids", oid)
+			continue
+		}
+		results.pdus = append(results.pdus, pdu)
+	}
+	for _, subtree := range newWalk {
+		results.pdus = append(results.pdus, walkPdus(pdus, subtree)...)
+	}
+	return results, nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/gosnmp/gosnmp"
+
+	"github.com/shatteredsilicon/snmp_exporter/config"
+	"github.com/shatteredsilicon/snmp_exporter/snmprec"
+)
+
+func TestRecordPath(t *testing.T) {
+	if got, want := recordPath("/tmp", "tcp://[::1]:1161"), filepath.Join("/tmp", "tcp______1__1161.snmprec"); got != want {
+		t.Errorf("want %s, got %s", want, got)
+	}
+	if got, want := recordPath("/tmp", "192.0.0.8"), filepath.Join("/tmp", "192.0.0.8.snmprec"); got != want {
+		t.Errorf("want %s, got %s", want, got)
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	dir := t.TempDir()
+	old := *recordDir
+	*recordDir = dir
+	defer func() { *recordDir = old }()
+
+	if err := recordPdus(dir, "192.0.0.8", []gosnmp.SnmpPDU{
+		{Name: ".1.3.6.1.2.1.2.2.1.2.10", Type: gosnmp.OctetString, Value: []byte("eth10")},
+		{Name: ".1.3.6.1.2.1.2.2.1.2.2", Type: gosnmp.OctetString, Value: []byte("lo")},
+		{Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.TimeTicks, Value: uint32(100)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// A second module's scrape is merged into the same file.
+	if err := recordPdus(dir, "192.0.0.8", []gosnmp.SnmpPDU{
+		{Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.TimeTicks, Value: uint32(200)},
+		{Name: ".1.3.6.1.2.1.2.2.1.10.2", Type: gosnmp.Counter32, Value: uint(7)},
+		{Name: ".1.3.6.1.2.1.2.2.1.10.10", Type: gosnmp.Counter32, Value: uint(9)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	pdus, err := snmprec.ReadFile(recordPath(dir, "192.0.0.8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, pdu := range pdus {
+		names = append(names, pdu.Name)
+	}
+	want := []string{".1.3.6.1.2.1.1.3.0", ".1.3.6.1.2.1.2.2.1.2.2", ".1.3.6.1.2.1.2.2.1.2.10", ".1.3.6.1.2.1.2.2.1.10.2", ".1.3.6.1.2.1.2.2.1.10.10"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Recorded OIDs: want %v, got %v", want, names)
+	}
+
+	module := &config.Module{
+		Get:  []string{"1.3.6.1.2.1.1.3.0", "1.3.6.1.2.1.1.5.0"},
+		Walk: []string{"1.3.6.1.2.1.2.2.1.2", "1.3.6.1.2.1.2.2.1.10"},
+		Filters: []config.DynamicFilter{
+			{Oid: "1.3.6.1.2.1.2.2.1.2", Targets: []string{"1.3.6.1.2.1.2.2.1.10"}, Values: []string{"^eth"}},
+		},
+	}
+	results, err := ScrapeTarget(context.Background(), ReplayScheme+"192.0.0.8", nil, module, log.NewNopLogger(), Metrics{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]interface{}{}
+	for _, pdu := range results.pdus {
+		got[pdu.Name] = pdu.Value
+	}
+	wantPdus := map[string]interface{}{
+		".1.3.6.1.2.1.1.3.0":       uint32(200),
+		".1.3.6.1.2.1.2.2.1.2.2":   []byte("lo"),
+		".1.3.6.1.2.1.2.2.1.2.10":  []byte("eth10"),
+		".1.3.6.1.2.1.2.2.1.10.10": uint(9),
+	}
+	if !reflect.DeepEqual(got, wantPdus) {
+		t.Errorf("Replayed PDUs: want %v, got %v", wantPdus, got)
+	}
+
+	if _, err := ScrapeTarget(context.Background(), ReplayScheme+"192.0.0.9", nil, module, log.NewNopLogger(), Metrics{}); err == nil {
+		t.Error("Expected error replaying an unrecorded target")
+	}
+
+	// Replayed targets are scraped with the first of a list of auths,
+	// without probing them.
+	t.Cleanup(ResetAuthCache)
+	auth := &config.Auth{Community: "public", SecurityLevel: "noAuthNoPriv", Version: 2}
+	auths := []*NamedAuth{NewNamedAuth("first", auth), NewNamedAuth("second", auth)}
+	module.Metrics = []*config.Metric{{Name: "sysUpTime", Oid: "1.3.6.1.2.1.1.3", Type: "gauge", Help: "Uptime"}}
+	c := New(context.Background(), ReplayScheme+"192.0.0.8", auths, []*NamedModule{NewNamedModule("replay", module)}, log.NewNopLogger(), testMetrics(), 1)
+	out, err := collectText(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`snmp_auth_selected{auth="first"} 1`, "sysUpTime 200"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in replayed output:\n%s", want, out)
+		}
+	}
+	if name, ok := selectedAuths.get(authCacheKey(ReplayScheme+"192.0.0.8", auths), time.Now()); ok {
+		t.Errorf("Expected no auth remembered for a replayed target, got %q", name)
+	}
+}
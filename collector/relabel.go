@@ -0,0 +1,147 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/shatteredsilicon/snmp_exporter/config"
+)
+
+// relabeler applies the metric relabel rules of a module to samples.
+type relabeler struct {
+	rules  []*config.RelabelConfig
+	logger log.Logger
+}
+
+func newRelabeler(rules []*config.RelabelConfig, logger log.Logger) relabeler {
+	return relabeler{rules: rules, logger: logger}
+}
+
+// newConstMetric is prometheus.NewConstMetric with the relabel rules
+// applied first. It returns no metric for samples the rules drop, or turn
+// into an invalid sample, which would fail the whole scrape.
+func (r relabeler) newConstMetric(name, help string, t prometheus.ValueType, value float64, labelnames, labelvalues []string) (prometheus.Metric, error) {
+	if len(r.rules) == 0 {
+		return prometheus.NewConstMetric(prometheus.NewDesc(name, help, labelnames, nil), t, value, labelvalues...)
+	}
+	labels := make(map[string]string, len(labelnames)+1)
+	for i, name := range labelnames {
+		labels[name] = labelvalues[i]
+	}
+	labels[config.MetricNameLabel] = name
+	if !r.apply(labels) {
+		return nil, nil
+	}
+	name = labels[config.MetricNameLabel]
+	delete(labels, config.MetricNameLabel)
+	labelnames = make([]string, 0, len(labels))
+	labelvalues = make([]string, 0, len(labels))
+	for k, v := range labels {
+		labelnames = append(labelnames, k)
+		labelvalues = append(labelvalues, v)
+	}
+	sample, err := prometheus.NewConstMetric(prometheus.NewDesc(name, help, labelnames, nil), t, value, labelvalues...)
+	if err != nil {
+		level.Info(r.logger).Log("msg", "Dropping sample made invalid by metric relabel configs", "metric", name, "err", err)
+		return nil, nil
+	}
+	return sample, nil
+}
+
+// apply applies the rules to the labels in place, and returns false if the
+// sample is dropped.
+func (r relabeler) apply(labels map[string]string) bool {
+	for _, rule := range r.rules {
+		values := make([]string, len(rule.SourceLabels))
+		for i, name := range rule.SourceLabels {
+			values[i] = labels[name]
+		}
+		value := strings.Join(values, rule.Separator)
+
+		switch rule.Action {
+		case config.RelabelDrop:
+			if rule.Regex.MatchString(value) {
+				return false
+			}
+		case config.RelabelKeep:
+			if !rule.Regex.MatchString(value) {
+				return false
+			}
+		case config.RelabelReplace:
+			indexes := rule.Regex.FindStringSubmatchIndex(value)
+			if indexes == nil {
+				break
+			}
+			target := string(rule.Regex.ExpandString(nil, rule.TargetLabel, value, indexes))
+			res := string(rule.Regex.ExpandString(nil, rule.Replacement, value, indexes))
+			if res == "" {
+				if target != config.MetricNameLabel {
+					delete(labels, target)
+				}
+				break
+			}
+			labels[target] = res
+		case config.RelabelHashMod:
+			sum := md5.Sum([]byte(value))
+			mod := binary.BigEndian.Uint64(sum[8:]) % rule.Modulus
+			labels[rule.TargetLabel] = fmt.Sprintf("%d", mod)
+		case config.RelabelLabelMap, config.RelabelLabelRename:
+			renamed := map[string]string{}
+			for name, v := range labels {
+				if name == config.MetricNameLabel || !rule.Regex.MatchString(name) {
+					continue
+				}
+				renamed[rule.Regex.ReplaceAllString(name, rule.Replacement)] = v
+				if rule.Action == config.RelabelLabelRename {
+					delete(labels, name)
+				}
+			}
+			for name, v := range renamed {
+				labels[name] = v
+			}
+		case config.RelabelLabelDrop:
+			for name := range labels {
+				if name != config.MetricNameLabel && rule.Regex.MatchString(name) {
+					delete(labels, name)
+				}
+			}
+		case config.RelabelLabelKeep:
+			for name := range labels {
+				if name != config.MetricNameLabel && !rule.Regex.MatchString(name) {
+					delete(labels, name)
+				}
+			}
+		}
+	}
+	return true
+}
+
+// withoutDropped removes the samples the relabel rules dropped.
+func withoutDropped(samples []prometheus.Metric) []prometheus.Metric {
+	kept := samples[:0]
+	for _, sample := range samples {
+		if sample != nil {
+			kept = append(kept, sample)
+		}
+	}
+	return kept
+}
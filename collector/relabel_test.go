@@ -0,0 +1,207 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/gosnmp/gosnmp"
+	io_prometheus_client "github.com/prometheus/client_model/go"
+	"gopkg.in/yaml.v2"
+
+	"github.com/shatteredsilicon/snmp_exporter/config"
+)
+
+func TestRelabel(t *testing.T) {
+	metric := &config.Metric{
+		Name: "ifMtu",
+		Oid:  "1.3.6.1.2.1.2.2.1.4",
+		Type: "gauge",
+		Help: "Help string",
+		Indexes: []*config.Index{
+			{Labelname: "ifIndex", Type: "gauge"},
+		},
+		Lookups: []*config.Lookup{
+			{Labels: []string{"ifIndex"}, Labelname: "ifDescr", Oid: "1.3.6.1.2.1.2.2.1.2", Type: "DisplayString"},
+		},
+	}
+	oidToPdu := map[string]gosnmp.SnmpPDU{
+		"1.3.6.1.2.1.2.2.1.2.1": {Name: "1.3.6.1.2.1.2.2.1.2.1", Type: gosnmp.OctetString, Value: []byte("eth0")},
+		"1.3.6.1.2.1.2.2.1.2.2": {Name: "1.3.6.1.2.1.2.2.1.2.2", Type: gosnmp.OctetString, Value: []byte("lo")},
+	}
+
+	cases := []struct {
+		name   string
+		rules  string
+		index  int
+		want   string
+		labels map[string]string
+	}{
+		{
+			name:   "no rules",
+			index:  1,
+			want:   "ifMtu",
+			labels: map[string]string{"ifIndex": "1", "ifDescr": "eth0"},
+		},
+		{
+			name: "rename and label rename",
+			rules: `
+- source_labels: [__name__]
+  regex: ifMtu
+  target_label: __name__
+  replacement: node_network_mtu_bytes
+- action: labelrename
+  regex: ifDescr
+  replacement: device
+`,
+			index:  1,
+			want:   "node_network_mtu_bytes",
+			labels: map[string]string{"ifIndex": "1", "device": "eth0"},
+		},
+		{
+			name: "drop",
+			rules: `
+- source_labels: [ifDescr]
+  regex: lo
+  action: drop
+`,
+			index: 2,
+		},
+		{
+			name: "keep",
+			rules: `
+- source_labels: [ifDescr]
+  regex: eth.*
+  action: keep
+`,
+			index:  1,
+			want:   "ifMtu",
+			labels: map[string]string{"ifIndex": "1", "ifDescr": "eth0"},
+		},
+		{
+			name: "keep drops others",
+			rules: `
+- source_labels: [ifDescr]
+  regex: eth.*
+  action: keep
+`,
+			index: 2,
+		},
+		{
+			name: "replace label value and drop label",
+			rules: `
+- source_labels: [ifDescr]
+  regex: eth(.*)
+  target_label: port
+  replacement: port$1
+- action: labeldrop
+  regex: ifDescr
+`,
+			index:  1,
+			want:   "ifMtu",
+			labels: map[string]string{"ifIndex": "1", "port": "port0"},
+		},
+		{
+			// The label name is only known to be invalid when relabelling.
+			name: "invalid label dropped",
+			rules: `
+- source_labels: [ifIndex]
+  target_label: $1
+  replacement: x
+`,
+			index: 1,
+		},
+		{
+			name: "hashmod",
+			rules: `
+- source_labels: [ifDescr]
+  modulus: 1
+  target_label: shard
+  action: hashmod
+`,
+			index:  1,
+			want:   "ifMtu",
+			labels: map[string]string{"ifIndex": "1", "ifDescr": "eth0", "shard": "0"},
+		},
+	}
+	for _, c := range cases {
+		var rules []*config.RelabelConfig
+		if err := yaml.UnmarshalStrict([]byte(c.rules), &rules); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		pdu := &gosnmp.SnmpPDU{Name: metric.Oid, Type: gosnmp.Integer, Value: 1500}
+		samples := pduToSamples([]int{c.index}, pdu, metric, oidToPdu, newRelabeler(rules, log.NewNopLogger()), log.NewNopLogger(), Metrics{})
+		if c.want == "" {
+			if len(samples) != 0 {
+				t.Errorf("%s: want sample dropped, got %v", c.name, samples)
+			}
+			continue
+		}
+		if len(samples) != 1 {
+			t.Fatalf("%s: want 1 sample, got %d", c.name, len(samples))
+		}
+		if got := samples[0].Desc().String(); !strings.Contains(got, `fqName: "`+c.want+`"`) {
+			t.Errorf("%s: want metric %s, got %s", c.name, c.want, got)
+		}
+		m := &io_prometheus_client.Metric{}
+		if err := samples[0].Write(m); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		labels := map[string]string{}
+		for _, l := range m.Label {
+			labels[l.GetName()] = l.GetValue()
+		}
+		if len(labels) != len(c.labels) {
+			t.Errorf("%s: want labels %v, got %v", c.name, c.labels, labels)
+		}
+		for k, v := range c.labels {
+			if labels[k] != v {
+				t.Errorf("%s: want labels %v, got %v", c.name, c.labels, labels)
+				break
+			}
+		}
+	}
+}
+
+func TestRelabelConfigValidation(t *testing.T) {
+	for _, rules := range []string{
+		"- action: replace\n",
+		"- action: hashmod\n  target_label: shard\n",
+		"- action: keep\n",
+		"- action: unknown\n",
+		"- source_labels: [not-a-label]\n  target_label: x\n",
+		"- source_labels: [ifDescr]\n  target_label: port-$1\n",
+		"- source_labels: [ifDescr]\n  target_label: __name__\n  replacement: if-$1\n",
+		"- action: labelmap\n  regex: if(.*)\n  replacement: 1$1\n",
+		"- action: labelrename\n  regex: ifDescr\n  replacement: device name\n",
+	} {
+		var c []*config.RelabelConfig
+		if err := yaml.UnmarshalStrict([]byte(rules), &c); err == nil {
+			t.Errorf("want error for %q", rules)
+		}
+	}
+	for _, rules := range []string{
+		"- source_labels: [ifDescr]\n  target_label: ${1}_port\n",
+		"- source_labels: [ifDescr]\n  target_label: __name__\n  replacement: node_$1\n",
+		"- action: labelmap\n  regex: if(.*)\n",
+		"- action: labelrename\n  regex: ifDescr\n  replacement: device\n",
+	} {
+		var c []*config.RelabelConfig
+		if err := yaml.UnmarshalStrict([]byte(rules), &c); err != nil {
+			t.Errorf("unexpected error for %q: %v", rules, err)
+		}
+	}
+}
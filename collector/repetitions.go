@@ -0,0 +1,108 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultRepetitionsTTL is how long the max repetitions learned for a target
+// are kept after its last walk, unless the module sets another TTL.
+const defaultRepetitionsTTL = time.Hour
+
+type repetitionsEntry struct {
+	value    uint32
+	lastSeen time.Time
+	ttl      time.Duration
+}
+
+// repetitionsStore remembers the max repetitions learned for each target
+// with adaptive max repetitions, and forgets targets which haven't been
+// walked for longer than the TTL of the module that last walked them.
+type repetitionsStore struct {
+	mu      sync.Mutex
+	entries map[string]*repetitionsEntry
+}
+
+func newRepetitionsStore() *repetitionsStore {
+	return &repetitionsStore{
+		entries: make(map[string]*repetitionsEntry),
+	}
+}
+
+var learnedRepetitions = newRepetitionsStore()
+
+// get returns the max repetitions to use for the target, at most max.
+func (s *repetitionsStore) get(target string, max uint32, ttl time.Duration, now time.Time) uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evictLocked(now)
+	e, ok := s.entries[target]
+	if !ok {
+		return max
+	}
+	e.lastSeen = now
+	e.ttl = ttl
+	if e.value > max {
+		return max
+	}
+	return e.value
+}
+
+// decrease halves the max repetitions of the target after a walk with
+// used repetitions failed, and returns the new value.
+func (s *repetitionsStore) decrease(target string, used uint32, ttl time.Duration, now time.Time) uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value := used / 2
+	if value < 1 {
+		value = 1
+	}
+	e, ok := s.entries[target]
+	if !ok {
+		e = &repetitionsEntry{value: value}
+		s.entries[target] = e
+	}
+	if value < e.value {
+		e.value = value
+	}
+	e.lastSeen = now
+	e.ttl = ttl
+	return e.value
+}
+
+// increase grows the max repetitions of the target by one after a
+// successful walk, up to max.
+func (s *repetitionsStore) increase(target string, max uint32, ttl time.Duration, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[target]
+	if !ok {
+		return
+	}
+	if e.value < max {
+		e.value++
+	}
+	e.lastSeen = now
+	e.ttl = ttl
+}
+
+func (s *repetitionsStore) evictLocked(now time.Time) {
+	for target, e := range s.entries {
+		if now.Sub(e.lastSeen) > e.ttl {
+			delete(s.entries, target)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+
+	"github.com/shatteredsilicon/snmp_exporter/config"
+)
+
+func TestRepetitionsStore(t *testing.T) {
+	store := newRepetitionsStore()
+	now := time.Now()
+	if got := store.get("a", 25, time.Minute, now); got != 25 {
+		t.Errorf("want the configured value for an unknown target, got %d", got)
+	}
+	// Growing doesn't start tracking a target.
+	store.increase("a", 25, time.Minute, now)
+	if len(store.entries) != 0 {
+		t.Errorf("want no entries, got %d", len(store.entries))
+	}
+	if got := store.decrease("a", 25, time.Minute, now); got != 12 {
+		t.Errorf("want 12 after halving, got %d", got)
+	}
+	// A concurrent walk halving a larger value doesn't raise it again.
+	if got := store.decrease("a", 25, time.Minute, now); got != 12 {
+		t.Errorf("want 12 after halving, got %d", got)
+	}
+	store.increase("a", 25, time.Minute, now)
+	if got := store.get("a", 25, time.Minute, now); got != 13 {
+		t.Errorf("want 13 after growing, got %d", got)
+	}
+	// Modules with a lower max repetitions use theirs.
+	if got := store.get("a", 10, time.Minute, now); got != 10 {
+		t.Errorf("want 10, got %d", got)
+	}
+	store.increase("a", 10, time.Minute, now)
+	if got := store.get("a", 25, time.Minute, now); got != 13 {
+		t.Errorf("want 13, got %d", got)
+	}
+	if got := store.decrease("b", 1, time.Minute, now); got != 1 {
+		t.Errorf("want at least 1, got %d", got)
+	}
+	if got := store.get("a", 25, time.Minute, now.Add(2*time.Minute)); got != 25 {
+		t.Errorf("want the configured value after the TTL, got %d", got)
+	}
+}
+
+func TestScrapeTargetAdaptiveMaxRepetitions(t *testing.T) {
+	agent := startTestAgent(t)
+	agent.SetMaxVarbinds(10)
+	retries := 0
+	adaptive := true
+	module := &config.Module{
+		Walk: []string{"1.3.6.1.2.1.2.2.1"},
+		WalkParams: config.WalkParams{
+			MaxRepetitions:         25,
+			Retries:                &retries,
+			Timeout:                200 * time.Millisecond,
+			AdaptiveMaxRepetitions: &adaptive,
+		},
+	}
+	auth := &config.Auth{Community: "public", SecurityLevel: "noAuthNoPriv", Version: 2}
+	results, err := ScrapeTarget(context.Background(), agent.Addr(), auth, module, log.NewNopLogger(), testMetrics())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.pdus) != 10 {
+		t.Errorf("want 10 PDUs, got %d", len(results.pdus))
+	}
+	// 25 and 12 are too big, 6 works and then grows by one.
+	if results.maxRepetitions != 7 {
+		t.Errorf("want 7 max repetitions, got %d", results.maxRepetitions)
+	}
+
+	agent.SetMaxVarbinds(0)
+	results, err = ScrapeTarget(context.Background(), agent.Addr(), auth, module, log.NewNopLogger(), testMetrics())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.maxRepetitions != 8 {
+		t.Errorf("want 8 max repetitions, got %d", results.maxRepetitions)
+	}
+
+	// Without adaptive max repetitions the walk ends at tooBig, like
+	// gosnmp's walks.
+	adaptive = false
+	agent.SetMaxVarbinds(10)
+	results, err = ScrapeTarget(context.Background(), agent.Addr(), auth, module, log.NewNopLogger(), testMetrics())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.pdus) != 0 {
+		t.Errorf("want no PDUs without adaptive max repetitions, got %d", len(results.pdus))
+	}
+}
+
+func TestScrapeTargetAdaptiveMaxRepetitionsTimeout(t *testing.T) {
+	agent := startTestAgent(t)
+	agent.Drop(-1)
+	retries := 0
+	adaptive := true
+	module := &config.Module{
+		Walk: []string{"1.3.6.1.2.1.2.2.1"},
+		WalkParams: config.WalkParams{
+			MaxRepetitions:         25,
+			Retries:                &retries,
+			Timeout:                50 * time.Millisecond,
+			AdaptiveMaxRepetitions: &adaptive,
+		},
+	}
+	auth := &config.Auth{Community: "public", SecurityLevel: "noAuthNoPriv", Version: 2}
+	if _, err := ScrapeTarget(context.Background(), agent.Addr(), auth, module, log.NewNopLogger(), testMetrics()); err == nil {
+		t.Fatal("expected a timeout")
+	}
+	// A device that doesn't answer is walked again once with 12, not down
+	// to a single repetition.
+	if got := agent.Requests(); got != 2 {
+		t.Errorf("want 2 requests, got %d", got)
+	}
+	if got := learnedRepetitions.get(agent.Addr(), 25, time.Hour, time.Now()); got != 12 {
+		t.Errorf("want 12 max repetitions, got %d", got)
+	}
+}
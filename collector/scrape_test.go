@@ -0,0 +1,393 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/gosnmp/gosnmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/shatteredsilicon/snmp_exporter/config"
+	"github.com/shatteredsilicon/snmp_exporter/snmpsim"
+)
+
+func testMetrics() Metrics {
+	return Metrics{
+		SNMPCollectionDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "collection_duration"}, []string{"module"}),
+		SNMPUnexpectedPduType:  prometheus.NewCounter(prometheus.CounterOpts{Name: "unexpected_pdu_type"}),
+		SNMPDuration:           prometheus.NewHistogram(prometheus.HistogramOpts{Name: "duration"}),
+		SNMPPackets:            prometheus.NewCounter(prometheus.CounterOpts{Name: "packets"}),
+		SNMPRetries:            prometheus.NewCounter(prometheus.CounterOpts{Name: "retries"}),
+		SNMPSessionPoolHits:    prometheus.NewCounter(prometheus.CounterOpts{Name: "hits"}),
+		SNMPSessionPoolMisses:  prometheus.NewCounter(prometheus.CounterOpts{Name: "misses"}),
+	}
+}
+
+func startTestAgent(t *testing.T) *snmpsim.Agent {
+	t.Helper()
+	agent, err := snmpsim.Load("../testdata/device.snmprec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := agent.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { agent.Close() })
+	return agent
+}
+
+// collectText returns the samples of the collector in the text format.
+func collectText(c prometheus.Collector) (string, error) {
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(c); err != nil {
+		return "", err
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	for _, mf := range mfs {
+		if _, err := expfmt.MetricFamilyToText(&buf, mf); err != nil {
+			return "", err
+		}
+	}
+	return buf.String(), nil
+}
+
+func pduNames(pdus []gosnmp.SnmpPDU) []string {
+	names := []string{}
+	for _, pdu := range pdus {
+		names = append(names, pdu.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestScrapeTargetAgent(t *testing.T) {
+	agent := startTestAgent(t)
+	if err := agent.AddUser(snmpsim.User{Name: "user", AuthProtocol: gosnmp.SHA, AuthPassword: "authpassword", PrivProtocol: gosnmp.AES, PrivPassword: "privpassword"}); err != nil {
+		t.Fatal(err)
+	}
+	retries := 2
+	module := &config.Module{
+		Get:  []string{"1.3.6.1.2.1.1.3.0", "1.3.6.1.2.1.1.4.0"},
+		Walk: []string{"1.3.6.1.2.1.2.2.1.2", "1.3.6.1.2.1.2.2.1.10"},
+		Filters: []config.DynamicFilter{
+			{Oid: "1.3.6.1.2.1.2.2.1.2", Targets: []string{"1.3.6.1.2.1.2.2.1.10"}, Values: []string{"^eth"}},
+		},
+		WalkParams: config.WalkParams{
+			MaxRepetitions: 25,
+			Retries:        &retries,
+			Timeout:        200 * time.Millisecond,
+		},
+	}
+	want := []string{
+		".1.3.6.1.2.1.1.3.0",
+		".1.3.6.1.2.1.2.2.1.10.2",
+		".1.3.6.1.2.1.2.2.1.2.1",
+		".1.3.6.1.2.1.2.2.1.2.2",
+	}
+
+	auths := map[string]*config.Auth{
+		"public_v1": {Community: "public", SecurityLevel: "noAuthNoPriv", Version: 1},
+		"public_v2": {Community: "public", SecurityLevel: "noAuthNoPriv", Version: 2},
+		"user_v3":   {Username: "user", SecurityLevel: "authPriv", AuthProtocol: "SHA", Password: "authpassword", PrivProtocol: "AES", PrivPassword: "privpassword", Version: 3},
+	}
+	for name, auth := range auths {
+		// The first request is lost, so the scrape only succeeds through a retry.
+		agent.Drop(1)
+		results, err := ScrapeTarget(context.Background(), agent.Addr(), auth, module, log.NewNopLogger(), testMetrics())
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if got := pduNames(results.pdus); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: want PDUs %v, got %v", name, want, got)
+		}
+		if results.retries == 0 {
+			t.Errorf("%s: expected a retry to be counted", name)
+		}
+	}
+
+	agent.Drop(-1)
+	if _, err := ScrapeTarget(context.Background(), agent.Addr(), auths["public_v2"], module, log.NewNopLogger(), testMetrics()); err == nil {
+		t.Error("expected an error scraping an unresponsive agent")
+	}
+}
+
+func TestCheckAuthPooled(t *testing.T) {
+	agent := startTestAgent(t)
+	if err := agent.AddUser(snmpsim.User{Name: "user", AuthProtocol: gosnmp.SHA, AuthPassword: "authpassword"}); err != nil {
+		t.Fatal(err)
+	}
+	poolSize := *sessionPoolSize
+	*sessionPoolSize = 1
+	t.Cleanup(func() {
+		*sessionPoolSize = poolSize
+		sessions.reset()
+	})
+
+	auth := NewNamedAuth("user_v3", &config.Auth{Username: "user", SecurityLevel: "authNoPriv", AuthProtocol: "SHA", Password: "authpassword", Version: 3})
+	retries := 0
+	params := config.WalkParams{MaxRepetitions: 25, Retries: &retries, Timeout: time.Second}
+	// The checks share one pooled session, so reading the engine from it
+	// after it's handed back would race with the next check.
+	var wg sync.WaitGroup
+	results := make([]AuthCheckResult, 16)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = CheckAuth(context.Background(), agent.Addr(), auth, params, testMetrics())
+		}(i)
+	}
+	wg.Wait()
+	for i, result := range results {
+		if !result.Success {
+			t.Fatalf("check %d: expected success, got %s", i, result.Error)
+		}
+		if result.EngineID == "" || result.SysObjectID == "" {
+			t.Errorf("check %d: expected the engine ID and sysObjectID, got %+v", i, result)
+		}
+	}
+}
+
+func TestScrapeTargetWalkConcurrency(t *testing.T) {
+	agent := startTestAgent(t)
+	agent.SetLatency(10 * time.Millisecond)
+	retries := 0
+	module := &config.Module{
+		Walk: []string{"1.3.6.1.2.1.31.1.1.1.6", "1.3.6.1.2.1.2.2.1.2", "1.3.6.1.2.1.1", "1.3.6.1.2.1.2.2.1.10", "1.3.6.1.2.1.2.1.0"},
+		WalkParams: config.WalkParams{
+			MaxRepetitions: 2,
+			Retries:        &retries,
+			Timeout:        time.Second,
+		},
+	}
+	auth := &config.Auth{Community: "public", SecurityLevel: "noAuthNoPriv", Version: 2}
+	sequential, err := ScrapeTarget(context.Background(), agent.Addr(), auth, module, log.NewNopLogger(), testMetrics())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sequential.pdus) != 11 {
+		t.Fatalf("want 11 PDUs, got %d", len(sequential.pdus))
+	}
+	for _, concurrency := range []int{2, 3, 10} {
+		module.WalkParams.WalkConcurrency = concurrency
+		concurrent, err := ScrapeTarget(context.Background(), agent.Addr(), auth, module, log.NewNopLogger(), testMetrics())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(concurrent.pdus, sequential.pdus) {
+			t.Errorf("concurrency %d: want PDUs %v, got %v", concurrency, sequential.pdus, concurrent.pdus)
+		}
+		subtrees := []string{}
+		for _, d := range concurrent.subtreeDurations {
+			subtrees = append(subtrees, d.oid)
+		}
+		if !reflect.DeepEqual(subtrees, module.Walk) {
+			t.Errorf("concurrency %d: want subtree durations in the order %v, got %v", concurrency, module.Walk, subtrees)
+		}
+	}
+}
+
+func TestWalkSubtreesFailure(t *testing.T) {
+	agent := startTestAgent(t)
+	agent.SetLatency(10 * time.Millisecond)
+	agent.SetError("1.3.6.1.2.1.2.2.1.10", gosnmp.GenErr)
+	retries := 0
+	adaptive := true
+	// With adaptive max repetitions an error status fails the walk even
+	// without partial results.
+	params := config.WalkParams{
+		MaxRepetitions:         1,
+		Retries:                &retries,
+		Timeout:                time.Second,
+		AdaptiveMaxRepetitions: &adaptive,
+	}
+	auth := &config.Auth{Community: "public", SecurityLevel: "noAuthNoPriv", Version: 2}
+	// The second subtree fails right away, while the first takes a while.
+	subtrees := []string{"1.3.6.1.2.1.1", "1.3.6.1.2.1.2.2.1.10", "1.3.6.1.2.1.2.2.1.2", "1.3.6.1.2.1.31.1.1.1.6"}
+	for _, test := range []struct {
+		concurrency int
+		partial     bool
+		walked      []bool
+	}{
+		{concurrency: 1, partial: false, walked: []bool{true, true, false, false}},
+		{concurrency: 2, partial: false, walked: []bool{true, true, false, false}},
+		{concurrency: 1, partial: true, walked: []bool{true, true, true, true}},
+		{concurrency: 2, partial: true, walked: []bool{true, true, true, true}},
+	} {
+		walkers := []*gosnmp.GoSNMP{}
+		for i := 0; i < test.concurrency; i++ {
+			snmp, err := newSession(agent.Addr(), auth, params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			snmp.Context = context.Background()
+			if err := snmp.Connect(); err != nil {
+				t.Fatal(err)
+			}
+			defer snmp.Conn.Close()
+			walkers = append(walkers, snmp)
+		}
+		walks := walkSubtrees(walkers, agent.Addr(), subtrees, params, test.partial, log.NewNopLogger())
+		for i, w := range walks {
+			if w.walked != test.walked[i] {
+				t.Errorf("concurrency %d, partial %t: want subtree %s walked %t, got %t", test.concurrency, test.partial, subtrees[i], test.walked[i], w.walked)
+			}
+			if (w.err != nil) != (i == 1) {
+				t.Errorf("concurrency %d, partial %t: unexpected error for subtree %s: %v", test.concurrency, test.partial, subtrees[i], w.err)
+			}
+		}
+	}
+}
+
+func TestCollectPartial(t *testing.T) {
+	agent := startTestAgent(t)
+	agent.SetError("1.3.6.1.2.1.2.2.1.10", gosnmp.GenErr)
+	auth := &config.Auth{Community: "public", SecurityLevel: "noAuthNoPriv", Version: 2}
+	retries := 0
+	module := &config.Module{
+		Get:  []string{"1.3.6.1.2.1.1.3.0"},
+		Walk: []string{"1.3.6.1.2.1.2.2.1.2", "1.3.6.1.2.1.2.2.1.10", "1.3.6.1.2.1.31.1.1.1.6"},
+		Metrics: []*config.Metric{
+			{Name: "sysUpTime", Oid: "1.3.6.1.2.1.1.3", Type: "gauge", Help: "Uptime"},
+			{Name: "ifInOctets", Oid: "1.3.6.1.2.1.2.2.1.10", Type: "counter", Help: "Octets in",
+				Indexes: []*config.Index{{Labelname: "ifIndex", Type: "gauge"}}},
+			{Name: "ifHCInOctets", Oid: "1.3.6.1.2.1.31.1.1.1.6", Type: "counter", Help: "Octets in",
+				Indexes: []*config.Index{{Labelname: "ifIndex", Type: "gauge"}}},
+		},
+		WalkParams: config.WalkParams{MaxRepetitions: 25, Timeout: time.Second, Retries: &retries},
+		OnError:    config.OnErrorPartial,
+	}
+	c := New(context.Background(), agent.Addr(), []*NamedAuth{NewNamedAuth("public_v2", auth)},
+		[]*NamedModule{NewNamedModule("partial", module)}, log.NewNopLogger(), testMetrics(), 1)
+	out, err := collectText(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The subtrees around the failed one are still walked.
+	for _, want := range []string{
+		"sysUpTime 123456",
+		`ifHCInOctets{ifIndex="1"} 1e+11`,
+		`ifHCInOctets{ifIndex="2"} 2e+11`,
+		`snmp_scrape_subtree_errors{module="partial",oid="1.3.6.1.2.1.2.2.1.10"} 1`,
+		`snmp_scrape_error_reason{module="partial",reason="gen_err"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in output:\n%s", want, out)
+		}
+	}
+	// ifInOctets is renamed by the default SSM mappings.
+	if strings.Contains(out, "node_network_receive_bytes") {
+		t.Errorf("unexpected samples of the failed subtree:\n%s", out)
+	}
+
+}
+
+func TestCollectErrorStatus(t *testing.T) {
+	agent := startTestAgent(t)
+	// The agent fails partway through the table.
+	agent.SetError("1.3.6.1.2.1.2.2.1.10.2", gosnmp.GenErr)
+	auth := &config.Auth{Community: "public", SecurityLevel: "noAuthNoPriv", Version: 2}
+	retries := 0
+	module := &config.Module{
+		Get:  []string{"1.3.6.1.2.1.1.3.0"},
+		Walk: []string{"1.3.6.1.2.1.2.2.1.10", "1.3.6.1.2.1.31.1.1.1.6"},
+		Metrics: []*config.Metric{
+			{Name: "sysUpTime", Oid: "1.3.6.1.2.1.1.3", Type: "gauge", Help: "Uptime"},
+			{Name: "ifInOctets", Oid: "1.3.6.1.2.1.2.2.1.10", Type: "counter", Help: "Octets in",
+				Indexes: []*config.Index{{Labelname: "ifIndex", Type: "gauge"}}},
+			{Name: "ifHCInOctets", Oid: "1.3.6.1.2.1.31.1.1.1.6", Type: "counter", Help: "Octets in",
+				Indexes: []*config.Index{{Labelname: "ifIndex", Type: "gauge"}}},
+		},
+		WalkParams: config.WalkParams{MaxRepetitions: 1, Timeout: time.Second, Retries: &retries},
+	}
+	c := New(context.Background(), agent.Addr(), []*NamedAuth{NewNamedAuth("public_v2", auth)},
+		[]*NamedModule{NewNamedModule("error_status", module)}, log.NewNopLogger(), testMetrics(), 1)
+	out, err := collectText(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without partial results the walk ends at the error status and keeps
+	// what it received, as gosnmp's walks do.
+	for _, want := range []string{
+		"sysUpTime 123456",
+		// ifInOctets is renamed by the default SSM mappings.
+		`node_network_receive_bytes{ifIndex="1"} 1000`,
+		`ifHCInOctets{ifIndex="1"} 1e+11`,
+		`ifHCInOctets{ifIndex="2"} 2e+11`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in output:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, `node_network_receive_bytes{ifIndex="2"}`) || strings.Contains(out, "snmp_scrape_error_reason") {
+		t.Errorf("unexpected samples past the error status or of a failure:\n%s", out)
+	}
+}
+
+func TestCollectDeadline(t *testing.T) {
+	agent := startTestAgent(t)
+	agent.SetLatency(200 * time.Millisecond)
+	auth := &config.Auth{Community: "public", SecurityLevel: "noAuthNoPriv", Version: 2}
+	retries := 0
+	module := &config.Module{
+		Get:  []string{"1.3.6.1.2.1.1.3.0"},
+		Walk: []string{"1.3.6.1.2.1.2.2.1.10", "1.3.6.1.2.1.31.1.1.1.6"},
+		Metrics: []*config.Metric{
+			{Name: "sysUpTime", Oid: "1.3.6.1.2.1.1.3", Type: "gauge", Help: "Uptime"},
+			{Name: "ifInOctets", Oid: "1.3.6.1.2.1.2.2.1.10", Type: "counter", Help: "Octets in",
+				Indexes: []*config.Index{{Labelname: "ifIndex", Type: "gauge"}}},
+			{Name: "ifHCInOctets", Oid: "1.3.6.1.2.1.31.1.1.1.6", Type: "counter", Help: "Octets in",
+				Indexes: []*config.Index{{Labelname: "ifIndex", Type: "gauge"}}},
+		},
+		WalkParams: config.WalkParams{MaxRepetitions: 25, Timeout: 5 * time.Second, Retries: &retries},
+	}
+	// The get and the first walk take a request each, the deadline passes
+	// during the second walk.
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	c := New(ctx, agent.Addr(), []*NamedAuth{NewNamedAuth("public_v2", auth)},
+		[]*NamedModule{NewNamedModule("deadline", module)}, log.NewNopLogger(), testMetrics(), 1)
+	out, err := collectText(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"sysUpTime 123456",
+		// ifInOctets is renamed by the default SSM mappings.
+		`node_network_receive_bytes{ifIndex="1"} 1000`,
+		`node_network_receive_bytes{ifIndex="2"} 2000`,
+		`snmp_scrape_deadline_exceeded{module="deadline"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in output:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "ifHCInOctets") {
+		t.Errorf("unexpected samples of the subtree walked past the deadline:\n%s", out)
+	}
+}
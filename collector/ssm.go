@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/alecthomas/kingpin/v2"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/shatteredsilicon/snmp_exporter/config"
 )
@@ -38,7 +39,6 @@ type ssmMetricRecord struct {
 	hrSWRunPerfCPU    map[string]ssmMetricPerfCPU
 	hrSWRunName       map[string]string
 	collectedMetrics  map[string]struct{}
-	mu                sync.Mutex
 }
 
 func (r *ssmMetricRecord) totalCPUTicks() float64 {
@@ -54,12 +54,99 @@ func (r *ssmMetricRecord) totalCPUTicks() float64 {
 		r.ssCPURawGuest
 }
 
-var ssmMetricRecords = struct {
-	history map[string]*ssmMetricRecord
-	current map[string]*ssmMetricRecord
-}{
-	history: make(map[string]*ssmMetricRecord),
-	current: make(map[string]*ssmMetricRecord),
+var ssmStateTTL = kingpin.Flag("snmp.ssm-state-ttl", "How long SSM state of a target, auth and module combination is kept after its last scrape.").Default("10m").Duration()
+
+func newSSMMetricRecord() *ssmMetricRecord {
+	return &ssmMetricRecord{
+		collectedMetrics: make(map[string]struct{}),
+		hrSWRunPerfCPU:   make(map[string]ssmMetricPerfCPU),
+		hrSWRunName:      make(map[string]string),
+	}
+}
+
+// ssmRecordEntry holds the SSM state of a single target, auth and module
+// combination. The embedded mutex is held for the whole collection so
+// that concurrent scrapes of the same combination don't interleave.
+type ssmRecordEntry struct {
+	sync.Mutex
+	current  *ssmMetricRecord
+	history  *ssmMetricRecord
+	lastSeen time.Time
+}
+
+// ssmRecordStore tracks per-target SSM state and evicts entries which
+// haven't been scraped for longer than the TTL.
+type ssmRecordStore struct {
+	mu      sync.Mutex
+	entries map[string]*ssmRecordEntry
+	ttl     func() time.Duration
+}
+
+func newSSMRecordStore(ttl func() time.Duration) *ssmRecordStore {
+	return &ssmRecordStore{
+		entries: make(map[string]*ssmRecordEntry),
+		ttl:     ttl,
+	}
+}
+
+var ssmMetricRecords = newSSMRecordStore(func() time.Duration { return *ssmStateTTL })
+
+func ssmRecordKey(target, authName, module string) string {
+	return target + "\x00" + authName + "\x00" + module
+}
+
+// acquire returns the locked entry for the given key, creating it if
+// needed, with the per-scrape fields of the current record reset.
+// The caller must release the entry once it's done.
+func (s *ssmRecordStore) acquire(key string) *ssmRecordEntry {
+	now := time.Now()
+	s.mu.Lock()
+	s.evictLocked(now)
+	e, ok := s.entries[key]
+	if !ok {
+		e = &ssmRecordEntry{current: newSSMMetricRecord()}
+		s.entries[key] = e
+	}
+	e.lastSeen = now
+	s.mu.Unlock()
+
+	e.Lock()
+	e.current.hrSWRunPerfMem = 0
+	e.current.hrSWRunPerfCPU = make(map[string]ssmMetricPerfCPU)
+	e.current.hrSWRunName = make(map[string]string)
+	return e
+}
+
+// release saves the current record as history and unlocks the entry.
+func (e *ssmRecordEntry) release() {
+	e.copyHistorySSMMetrics()
+	e.Unlock()
+}
+
+func (s *ssmRecordStore) evictLocked(now time.Time) {
+	ttl := s.ttl()
+	if ttl <= 0 {
+		return
+	}
+	for key, e := range s.entries {
+		if now.Sub(e.lastSeen) > ttl {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// len returns the number of tracked entries, evicting idle ones first.
+func (s *ssmRecordStore) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evictLocked(time.Now())
+	return len(s.entries)
+}
+
+// SSMTrackedTargets returns the number of target, auth and module
+// combinations that currently have SSM state.
+func SSMTrackedTargets() int {
+	return ssmMetricRecords.len()
 }
 
 type ssmMetric struct {
@@ -73,39 +160,47 @@ type ssmMetric struct {
 		t prometheus.ValueType,
 		value float64,
 		labelNames, labelValues []string,
-		constLabels prometheus.Labels,
+		r relabeler,
 	) ([]prometheus.Metric, error)
 }
 
-var ssmMetrics = map[string]ssmMetric{
-	"hrSystemUptime": {
-		Type: config.MetricTypeGauge,
-		NewConstMetric: func(
-			metric *config.Metric,
-			t prometheus.ValueType,
-			value float64,
-			labelNames, labelValues []string,
-			constLabels prometheus.Labels,
-		) ([]prometheus.Metric, error) {
-			seconds := value / 100
-			nodeTime := float64(time.Now().Unix())
-			nodeBootTime := nodeTime - seconds
-
-			sample1, err := prometheus.NewConstMetric(prometheus.NewDesc("node_time", removeOidSuffix(metric.Help), labelNames, nil),
-				t, nodeTime, labelValues...)
-			if err != nil {
-				return nil, err
-			}
-
-			sample2, err := prometheus.NewConstMetric(prometheus.NewDesc("node_boot_time", removeOidSuffix(metric.Help), labelNames, nil),
-				t, nodeBootTime, labelValues...)
-			if err != nil {
-				return nil, err
-			}
-
-			return []prometheus.Metric{sample1, sample2}, nil
+// builtinSSMMetrics returns the conversions which can't be expressed in the
+// mapping file.
+func builtinSSMMetrics() map[string]ssmMetric {
+	return map[string]ssmMetric{
+		"hrSystemUptime": {
+			Type:           config.MetricTypeGauge,
+			NewConstMetric: uptimeConstMetrics,
+		},
+		"hrStorageSize": {
+			Type:           config.MetricTypeGauge,
+			NewConstMetric: filesystemConstMetrics,
 		},
-	},
+	}
+}
+
+func uptimeConstMetrics(
+	metric *config.Metric,
+	t prometheus.ValueType,
+	value float64,
+	labelNames, labelValues []string,
+	r relabeler,
+) ([]prometheus.Metric, error) {
+	seconds := value / 100
+	nodeTime := float64(time.Now().Unix())
+	nodeBootTime := nodeTime - seconds
+
+	sample1, err := r.newConstMetric("node_time", removeOidSuffix(metric.Help), t, nodeTime, labelNames, labelValues)
+	if err != nil {
+		return nil, err
+	}
+
+	sample2, err := r.newConstMetric("node_boot_time", removeOidSuffix(metric.Help), t, nodeBootTime, labelNames, labelValues)
+	if err != nil {
+		return nil, err
+	}
+
+	return []prometheus.Metric{sample1, sample2}, nil
 }
 
 func isSSMMetrics(metric *config.Metric) bool {
@@ -113,7 +208,7 @@ func isSSMMetrics(metric *config.Metric) bool {
 		return false
 	}
 
-	sm, ok := ssmMetrics[metric.Name]
+	sm, ok := currentSSMMetrics()[metric.Name]
 	return ok && sm.Type == metric.Type
 }
 
@@ -128,13 +223,13 @@ func newSSMConstMetric(
 	t prometheus.ValueType,
 	value float64,
 	labelNames, labelValues []string,
-	constLabels prometheus.Labels,
+	r relabeler,
 ) ([]prometheus.Metric, error) {
 	if !isSSMMetrics(metric) {
 		return nil, errors.New("not a SSM metric")
 	}
 
-	m := ssmMetrics[metric.Name]
+	m := currentSSMMetrics()[metric.Name]
 	name := metric.Name
 	if m.RenameTo != "" {
 		name = m.RenameTo
@@ -146,25 +241,20 @@ func newSSMConstMetric(
 		value = m.HandleValue(value)
 	}
 	if m.NewConstMetric != nil {
-		return m.NewConstMetric(metric, t, value, labelNames, labelValues, constLabels)
+		return m.NewConstMetric(metric, t, value, labelNames, labelValues, r)
 	}
 
 	help := removeOidSuffix(metric.Help)
 	if m.Help != "" {
 		help = m.Help
 	}
-	sample, err := prometheus.NewConstMetric(prometheus.NewDesc(name, help, labelNames, nil),
-		t, value, labelValues...)
+	sample, err := r.newConstMetric(name, help, t, value, labelNames, labelValues)
 	return []prometheus.Metric{sample}, err
 }
 
-func (c *Collector) copyHistorySSMMetrics() {
-	current, ok := ssmMetricRecords.current[c.target]
-	if !ok {
-		return
-	}
-
-	ssmMetricRecords.history[c.target] = &ssmMetricRecord{
+func (e *ssmRecordEntry) copyHistorySSMMetrics() {
+	current := e.current
+	e.history = &ssmMetricRecord{
 		ssCPURawUser:      current.ssCPURawUser,
 		ssCPURawNice:      current.ssCPURawNice,
 		ssCPURawSystem:    current.ssCPURawSystem,
@@ -0,0 +1,241 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"regexp"
+	"sync/atomic"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v2"
+
+	"github.com/shatteredsilicon/snmp_exporter/config"
+)
+
+var ssmMappingFile = kingpin.Flag("snmp.ssm-mapping-file", "Path to a YAML file converting SNMP metrics to node_exporter compatible series, instead of the built-in mappings. It is reloaded with the configuration.").Default("").String()
+
+//go:embed ssm_mappings.yml
+var defaultSSMMappings []byte
+
+var (
+	metricNameRE = regexp.MustCompile("^[a-zA-Z_:][a-zA-Z0-9_:]*$")
+	labelNameRE  = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+)
+
+// ssmMappingFileContent is the format of the SSM mapping file.
+type ssmMappingFileContent struct {
+	Metrics map[string]*ssmMapping `yaml:"metrics"`
+}
+
+// ssmMapping converts the samples of a metric to node_exporter compatible
+// series.
+type ssmMapping struct {
+	// The metric type the mapping applies to.
+	Type       string            `yaml:"type,omitempty"`
+	Rename     string            `yaml:"rename,omitempty"`
+	Help       string            `yaml:"help,omitempty"`
+	Multiply   float64           `yaml:"multiply,omitempty"`
+	Divide     float64           `yaml:"divide,omitempty"`
+	Labels     map[string]string `yaml:"labels,omitempty"`
+	DropLabels []string          `yaml:"drop_labels,omitempty"`
+	Split      *ssmSplit         `yaml:"split,omitempty"`
+}
+
+// ssmSplit turns the samples of a metric into different series depending
+// on the value of a label.
+type ssmSplit struct {
+	Label  string                 `yaml:"label"`
+	Series map[string]*ssmMapping `yaml:"series"`
+}
+
+func (m *ssmMapping) validate(series bool) error {
+	if series && m.Type != "" {
+		return fmt.Errorf("type can't be set for a series of a split")
+	}
+	if !series && m.Type == "" {
+		return fmt.Errorf("type is missing")
+	}
+	if m.Rename != "" && !metricNameRE.MatchString(m.Rename) {
+		return fmt.Errorf("invalid metric name %q", m.Rename)
+	}
+	for from, to := range m.Labels {
+		if !labelNameRE.MatchString(from) || !labelNameRE.MatchString(to) {
+			return fmt.Errorf("invalid label rename from %q to %q", from, to)
+		}
+	}
+	if m.Split == nil {
+		return nil
+	}
+	if series {
+		return fmt.Errorf("a series of a split can't be split")
+	}
+	if m.Split.Label == "" {
+		return fmt.Errorf("split label is missing")
+	}
+	if len(m.Split.Series) == 0 {
+		return fmt.Errorf("split series are missing")
+	}
+	for value, s := range m.Split.Series {
+		if err := s.validate(true); err != nil {
+			return fmt.Errorf("series %q: %w", value, err)
+		}
+	}
+	return nil
+}
+
+// handleLabels renames and removes labels.
+func (m *ssmMapping) handleLabels(labelNames, labelValues []string) ([]string, []string) {
+	lns := make([]string, 0, len(labelNames))
+	lvs := make([]string, 0, len(labelValues))
+LabelLoop:
+	for i, name := range labelNames {
+		for _, drop := range m.DropLabels {
+			if name == drop {
+				continue LabelLoop
+			}
+		}
+		if to, ok := m.Labels[name]; ok {
+			name = to
+		}
+		lns = append(lns, name)
+		lvs = append(lvs, labelValues[i])
+	}
+	return lns, lvs
+}
+
+func (m *ssmMapping) handleValue(value float64) float64 {
+	if m.Multiply != 0 {
+		value *= m.Multiply
+	}
+	if m.Divide != 0 {
+		value /= m.Divide
+	}
+	return value
+}
+
+func (m *ssmMapping) ssmMetric() ssmMetric {
+	sm := ssmMetric{
+		Type:     m.Type,
+		RenameTo: m.Rename,
+		Help:     m.Help,
+	}
+	if len(m.Labels) > 0 || len(m.DropLabels) > 0 {
+		sm.HandleLabels = m.handleLabels
+	}
+	if m.Multiply != 0 || m.Divide != 0 {
+		sm.HandleValue = m.handleValue
+	}
+	if m.Split != nil {
+		sm.NewConstMetric = m.Split.newConstMetric
+	}
+	return sm
+}
+
+// newConstMetric returns the series for the value of the split label, or
+// none if there's no series for it.
+func (s *ssmSplit) newConstMetric(
+	metric *config.Metric,
+	t prometheus.ValueType,
+	value float64,
+	labelNames, labelValues []string,
+	r relabeler,
+) ([]prometheus.Metric, error) {
+	for i, name := range labelNames {
+		if name != s.Label {
+			continue
+		}
+		series, ok := s.Series[labelValues[i]]
+		if !ok {
+			return nil, nil
+		}
+		lns := append(append([]string{}, labelNames[:i]...), labelNames[i+1:]...)
+		lvs := append(append([]string{}, labelValues[:i]...), labelValues[i+1:]...)
+		lns, lvs = series.handleLabels(lns, lvs)
+		name := metric.Name
+		if series.Rename != "" {
+			name = series.Rename
+		}
+		help := removeOidSuffix(metric.Help)
+		if series.Help != "" {
+			help = series.Help
+		}
+		sample, err := r.newConstMetric(name, help, t, series.handleValue(value), lns, lvs)
+		return []prometheus.Metric{sample}, err
+	}
+	return nil, nil
+}
+
+// parseSSMMappings returns the built-in conversions together with those of
+// the mapping file, which take precedence.
+func parseSSMMappings(content []byte) (map[string]ssmMetric, error) {
+	var file ssmMappingFileContent
+	if err := yaml.UnmarshalStrict(content, &file); err != nil {
+		return nil, err
+	}
+	metrics := builtinSSMMetrics()
+	for name, m := range file.Metrics {
+		if m == nil {
+			return nil, fmt.Errorf("metric %q: mapping is empty", name)
+		}
+		if err := m.validate(false); err != nil {
+			return nil, fmt.Errorf("metric %q: %w", name, err)
+		}
+		metrics[name] = m.ssmMetric()
+	}
+	return metrics, nil
+}
+
+var ssmMetrics atomic.Pointer[map[string]ssmMetric]
+
+func init() {
+	metrics, err := parseSSMMappings(defaultSSMMappings)
+	if err != nil {
+		panic(fmt.Sprintf("invalid built-in SSM mappings: %v", err))
+	}
+	ssmMetrics.Store(&metrics)
+}
+
+func currentSSMMetrics() map[string]ssmMetric {
+	return *ssmMetrics.Load()
+}
+
+// SSMMappingFile returns the path of the SSM mapping file, or an empty
+// string for the built-in mappings.
+func SSMMappingFile() string {
+	return *ssmMappingFile
+}
+
+// LoadSSMMappings loads the SSM mapping file given with
+// --snmp.ssm-mapping-file, or the built-in mappings. It must be called when
+// the configuration is reloaded.
+func LoadSSMMappings() error {
+	content := defaultSSMMappings
+	if *ssmMappingFile != "" {
+		var err error
+		content, err = os.ReadFile(*ssmMappingFile)
+		if err != nil {
+			return err
+		}
+	}
+	metrics, err := parseSSMMappings(content)
+	if err != nil {
+		return fmt.Errorf("error parsing SSM mapping file: %w", err)
+	}
+	ssmMetrics.Store(&metrics)
+	return nil
+}
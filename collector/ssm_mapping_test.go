@@ -0,0 +1,189 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/gosnmp/gosnmp"
+	io_prometheus_client "github.com/prometheus/client_model/go"
+
+	"github.com/shatteredsilicon/snmp_exporter/config"
+)
+
+var fqNameRE = regexp.MustCompile(`fqName: "([^"]*)"`)
+
+// ssmSamples returns the samples of the metric for a PDU with the value, as
+// "name{label=value,...} value" strings.
+func ssmSamples(t *testing.T, metric *config.Metric, indexOids []int, pdu gosnmp.SnmpPDU, oidToPdu map[string]gosnmp.SnmpPDU) []string {
+	out := []string{}
+	for _, sample := range pduToSamples(indexOids, &pdu, metric, oidToPdu, relabeler{}, log.NewNopLogger(), Metrics{}) {
+		m := &io_prometheus_client.Metric{}
+		if err := sample.Write(m); err != nil {
+			t.Fatal(err)
+		}
+		labels := []string{}
+		for _, l := range m.Label {
+			labels = append(labels, l.GetName()+"="+l.GetValue())
+		}
+		name := fqNameRE.FindStringSubmatch(sample.Desc().String())[1]
+		value := m.GetCounter().GetValue() + m.GetGauge().GetValue()
+		out = append(out, fmt.Sprintf("%s{%s} %g", name, strings.Join(labels, ","), value))
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestDefaultSSMMappings(t *testing.T) {
+	ifOidToPdu := map[string]gosnmp.SnmpPDU{
+		"1.3.6.1.2.1.2.2.1.2.1": {Name: "1.3.6.1.2.1.2.2.1.2.1", Type: gosnmp.OctetString, Value: []byte("eth0")},
+	}
+	laOidToPdu := map[string]gosnmp.SnmpPDU{
+		"1.3.6.1.4.1.2021.10.1.2.2": {Name: "1.3.6.1.4.1.2021.10.1.2.2", Type: gosnmp.OctetString, Value: []byte("Load-5")},
+		"1.3.6.1.4.1.2021.10.1.2.4": {Name: "1.3.6.1.4.1.2021.10.1.2.4", Type: gosnmp.OctetString, Value: []byte("Load-30")},
+	}
+	laLoadFloat := &config.Metric{
+		Name:    "laLoadFloat",
+		Oid:     "1.3.6.1.4.1.2021.10.1.6",
+		Type:    config.MetricTypeFloat,
+		Indexes: []*config.Index{{Labelname: "laIndex", Type: "gauge"}},
+		Lookups: []*config.Lookup{{Labels: []string{"laIndex"}, Labelname: "laNames", Oid: "1.3.6.1.4.1.2021.10.1.2", Type: "DisplayString"}},
+	}
+
+	cases := []struct {
+		name      string
+		metric    *config.Metric
+		indexOids []int
+		pdu       gosnmp.SnmpPDU
+		oidToPdu  map[string]gosnmp.SnmpPDU
+		want      []string
+	}{
+		{
+			name: "rename and label rename",
+			metric: &config.Metric{
+				Name:    "ifInOctets",
+				Oid:     "1.3.6.1.2.1.2.2.1.10",
+				Type:    config.MetricTypeCounter,
+				Indexes: []*config.Index{{Labelname: "ifIndex", Type: "gauge"}},
+				Lookups: []*config.Lookup{{Labels: []string{"ifIndex"}, Labelname: "ifDescr", Oid: "1.3.6.1.2.1.2.2.1.2", Type: "DisplayString"}},
+			},
+			indexOids: []int{1},
+			pdu:       gosnmp.SnmpPDU{Type: gosnmp.Counter32, Value: uint(100)},
+			oidToPdu:  ifOidToPdu,
+			want:      []string{"node_network_receive_bytes{device=eth0,ifIndex=1} 100"},
+		},
+		{
+			name:     "multiply",
+			metric:   &config.Metric{Name: "hrMemorySize", Oid: "1.3.6.1.2.1.25.2.2", Type: config.MetricTypeGauge},
+			pdu:      gosnmp.SnmpPDU{Type: gosnmp.Integer, Value: 2},
+			oidToPdu: map[string]gosnmp.SnmpPDU{},
+			want:     []string{"node_memory_MemTotal{} 2048"},
+		},
+		{
+			name:     "divide",
+			metric:   &config.Metric{Name: "ssIORawReceived", Oid: "1.3.6.1.4.1.2021.11.57", Type: config.MetricTypeCounter},
+			pdu:      gosnmp.SnmpPDU{Type: gosnmp.Counter32, Value: uint(10)},
+			oidToPdu: map[string]gosnmp.SnmpPDU{},
+			want:     []string{"node_vmstat_pgpgin{} 5"},
+		},
+		{
+			name:      "split",
+			metric:    laLoadFloat,
+			indexOids: []int{2},
+			pdu:       gosnmp.SnmpPDU{Type: gosnmp.OpaqueFloat, Value: float32(0.5)},
+			oidToPdu:  laOidToPdu,
+			want:      []string{"node_load5{} 0.5"},
+		},
+		{
+			name:      "split without series for the value",
+			metric:    laLoadFloat,
+			indexOids: []int{4},
+			pdu:       gosnmp.SnmpPDU{Type: gosnmp.OpaqueFloat, Value: float32(0.5)},
+			oidToPdu:  laOidToPdu,
+			want:      []string{},
+		},
+	}
+	for _, c := range cases {
+		got := ssmSamples(t, c.metric, c.indexOids, c.pdu, c.oidToPdu)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: want %v, got %v", c.name, c.want, got)
+		}
+	}
+}
+
+func TestLoadSSMMappings(t *testing.T) {
+	defer func() {
+		*ssmMappingFile = ""
+		if err := LoadSSMMappings(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	file := filepath.Join(t.TempDir(), "mappings.yml")
+	*ssmMappingFile = file
+	content := `metrics:
+  ifMtu:
+    type: gauge
+    rename: node_network_mtu_bytes
+    drop_labels: [ifIndex]
+`
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadSSMMappings(); err != nil {
+		t.Fatal(err)
+	}
+	metric := &config.Metric{
+		Name:    "ifMtu",
+		Oid:     "1.3.6.1.2.1.2.2.1.4",
+		Type:    config.MetricTypeGauge,
+		Indexes: []*config.Index{{Labelname: "ifIndex", Type: "gauge"}},
+	}
+	got := ssmSamples(t, metric, []int{1}, gosnmp.SnmpPDU{Type: gosnmp.Integer, Value: 1500}, map[string]gosnmp.SnmpPDU{})
+	if want := []string{"node_network_mtu_bytes{} 1500"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	// Mappings from the file replace the defaults, except the built-in ones.
+	if _, ok := currentSSMMetrics()["ifInOctets"]; ok {
+		t.Error("want default mappings replaced")
+	}
+	if _, ok := currentSSMMetrics()["hrStorageSize"]; !ok {
+		t.Error("want built-in conversions kept")
+	}
+
+	for _, content := range []string{
+		"metrics:\n  ifMtu:\n    rename: node_network_mtu_bytes\n",
+		"metrics:\n  ifMtu:\n    type: gauge\n    rename: not a name\n",
+		"metrics:\n  ifMtu:\n    type: gauge\n    split:\n      label: ifDescr\n",
+		"metrics:\n  ifMtu:\n    type: gauge\n    unknown: true\n",
+	} {
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := LoadSSMMappings(); err == nil {
+			t.Errorf("want error for %q", content)
+		}
+	}
+	// Failed loads keep the mappings in use.
+	if _, ok := currentSSMMetrics()["ifMtu"]; !ok {
+		t.Error("want mappings kept after a failed load")
+	}
+}
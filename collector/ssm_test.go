@@ -0,0 +1,96 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSSMRecordStore(t *testing.T) {
+	store := newSSMRecordStore(func() time.Duration { return time.Hour })
+
+	e := store.acquire(ssmRecordKey("a", "public_v2", "ssm_mib"))
+	e.current.ssCPURawUser = 10
+	e.current.hrSWRunPerfMem = 5
+	e.release()
+	if e.history == nil || e.history.ssCPURawUser != 10 {
+		t.Fatalf("history not saved on release: %+v", e.history)
+	}
+
+	e2 := store.acquire(ssmRecordKey("a", "public_v2", "ssm_mib"))
+	if e2 != e {
+		t.Fatal("expected the same entry for the same key")
+	}
+	if e2.current.hrSWRunPerfMem != 0 {
+		t.Errorf("per-scrape fields not reset, hrSWRunPerfMem: %v", e2.current.hrSWRunPerfMem)
+	}
+	e2.release()
+
+	for _, key := range []string{
+		ssmRecordKey("a", "public_v3", "ssm_mib"),
+		ssmRecordKey("a", "public_v2", "if_mib"),
+		ssmRecordKey("b", "public_v2", "ssm_mib"),
+	} {
+		store.acquire(key).release()
+	}
+	if got := store.len(); got != 4 {
+		t.Errorf("expected 4 tracked entries, got %d", got)
+	}
+}
+
+func TestSSMRecordStoreEviction(t *testing.T) {
+	ttl := time.Hour
+	store := newSSMRecordStore(func() time.Duration { return ttl })
+	store.acquire(ssmRecordKey("a", "public_v2", "ssm_mib")).release()
+	store.acquire(ssmRecordKey("b", "public_v2", "ssm_mib")).release()
+
+	store.mu.Lock()
+	store.entries[ssmRecordKey("a", "public_v2", "ssm_mib")].lastSeen = time.Now().Add(-2 * time.Hour)
+	store.mu.Unlock()
+
+	if got := store.len(); got != 1 {
+		t.Errorf("expected idle entry to be evicted, got %d entries", got)
+	}
+
+	ttl = 0
+	store.mu.Lock()
+	store.entries[ssmRecordKey("b", "public_v2", "ssm_mib")].lastSeen = time.Now().Add(-2 * time.Hour)
+	store.mu.Unlock()
+	if got := store.len(); got != 1 {
+		t.Errorf("expected no eviction with a zero TTL, got %d entries", got)
+	}
+}
+
+func TestSSMRecordStoreConcurrent(t *testing.T) {
+	store := newSSMRecordStore(func() time.Duration { return time.Hour })
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			e := store.acquire(ssmRecordKey(fmt.Sprintf("target%d", i%5), "public_v2", "ssm_mib"))
+			e.current.hrSWRunPerfMem += 1
+			e.current.collectedMetrics["hrSWRunPerfMem"] = struct{}{}
+			e.release()
+			store.len()
+		}(i)
+	}
+	wg.Wait()
+	if got := store.len(); got != 5 {
+		t.Errorf("expected 5 tracked entries, got %d", got)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// The max repetitions gosnmp uses if none are set.
+const defaultMaxRepetitions = 50
+
+// walkAll walks the subtree under rootOid with GetBulk requests, or GetNext
+// requests for SNMPv1. It mirrors gosnmp's WalkAll and BulkWalkAll, which
+// end the walk with the PDUs received so far on an error status in a
+// response. Unless endOnErrorStatus is set, the error status fails the walk
+// instead, for partial results and adaptive max repetitions to act on.
+func walkAll(snmp *gosnmp.GoSNMP, rootOid string, endOnErrorStatus bool) ([]gosnmp.SnmpPDU, error) {
+	rootOid = "." + strings.TrimPrefix(rootOid, ".")
+	requestType := gosnmp.GetBulkRequest
+	if snmp.Version == gosnmp.Version1 {
+		requestType = gosnmp.GetNextRequest
+	}
+	maxReps := snmp.MaxRepetitions
+	if maxReps == 0 {
+		maxReps = defaultMaxRepetitions
+	}
+	_, noCheck := snmp.AppOpts["c"]
+
+	var results []gosnmp.SnmpPDU
+	oid := rootOid
+	for requests := 1; ; requests++ {
+		var response *gosnmp.SnmpPacket
+		var err error
+		switch requestType {
+		case gosnmp.GetBulkRequest:
+			response, err = snmp.GetBulk([]string{oid}, uint8(snmp.NonRepeaters), maxReps)
+		case gosnmp.GetNextRequest:
+			response, err = snmp.GetNext([]string{oid})
+		default:
+			response, err = snmp.Get([]string{oid})
+		}
+		if err != nil {
+			return results, err
+		}
+		if len(response.Variables) == 0 {
+			return results, nil
+		}
+		if response.Error != gosnmp.NoError {
+			// SNMPv1 agents signal the end of the MIB view with noSuchName.
+			if endOnErrorStatus || response.Error == gosnmp.NoSuchName && snmp.Version == gosnmp.Version1 {
+				return results, nil
+			}
+			return results, &errorStatusError{status: response.Error, oid: oid}
+		}
+
+		for i, pdu := range response.Variables {
+			if pdu.Type == gosnmp.EndOfMibView || pdu.Type == gosnmp.NoSuchObject || pdu.Type == gosnmp.NoSuchInstance {
+				return results, nil
+			}
+			if !strings.HasPrefix(pdu.Name, rootOid+".") {
+				// The root is a leaf, so the first PDU returned is already past it.
+				if requests == 1 && i == 0 {
+					requestType = gosnmp.GetRequest
+					break
+				}
+				if pdu.Name == rootOid {
+					results = append(results, pdu)
+				}
+				return results, nil
+			}
+			if !(noCheck && requestType != gosnmp.GetRequest) && pdu.Name == oid {
+				return results, fmt.Errorf("OID not increasing: %s", pdu.Name)
+			}
+			results = append(results, pdu)
+		}
+		if requestType != gosnmp.GetRequest {
+			oid = response.Variables[len(response.Variables)-1].Name
+		}
+	}
+}
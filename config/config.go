@@ -14,10 +14,14 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/gosnmp/gosnmp"
@@ -41,29 +45,162 @@ const (
 	MetricTypeEnumAsStateSet = "EnumAsStateSet"
 	// MetricTypeBits - metric type "Bits"
 	MetricTypeBits = "Bits"
+
+	// OnErrorFail - fail the whole module when a get or walk fails
+	OnErrorFail = "fail"
+	// OnErrorPartial - keep the results of successful gets and walks
+	OnErrorPartial = "partial"
+
+	// RelabelReplace - set the target label to the replacement if the regex matches
+	RelabelReplace = "replace"
+	// RelabelKeep - drop samples the regex doesn't match
+	RelabelKeep = "keep"
+	// RelabelDrop - drop samples the regex matches
+	RelabelDrop = "drop"
+	// RelabelHashMod - set the target label to the hash of the source labels modulo the modulus
+	RelabelHashMod = "hashmod"
+	// RelabelLabelMap - copy labels whose name the regex matches to the replacement
+	RelabelLabelMap = "labelmap"
+	// RelabelLabelRename - rename labels whose name the regex matches to the replacement
+	RelabelLabelRename = "labelrename"
+	// RelabelLabelDrop - remove labels whose name the regex matches
+	RelabelLabelDrop = "labeldrop"
+	// RelabelLabelKeep - remove labels whose name the regex doesn't match
+	RelabelLabelKeep = "labelkeep"
+
+	// MetricNameLabel - the pseudo label holding the metric name in relabel rules
+	MetricNameLabel = "__name__"
 )
 
 func LoadFile(paths []string) (*Config, error) {
-	cfg := &Config{}
+	cfg := &Config{
+		Auths:   map[string]*Auth{},
+		Modules: map[string]*Module{},
+		Targets: map[string]*Target{},
+	}
+	// The files the auths, modules and targets were defined in.
+	authSources := map[string]string{}
+	moduleSources := map[string]string{}
+	targetSources := map[string]string{}
+	loaded := map[string]bool{}
+	hash := sha256.New()
 	for _, p := range paths {
 		files, err := filepath.Glob(p)
 		if err != nil {
 			return nil, err
 		}
 		for _, f := range files {
+			// Overlapping globs must not make a file conflict with itself.
+			if loaded[f] {
+				continue
+			}
+			loaded[f] = true
 			content, err := os.ReadFile(f)
 			if err != nil {
 				return nil, err
 			}
-			err = yaml.UnmarshalStrict(content, cfg)
+			hash.Write(content)
+			fileCfg := &Config{}
+			err = yaml.UnmarshalStrict(content, fileCfg)
 			if err != nil {
+				return nil, fmt.Errorf("error parsing %s: %w", f, err)
+			}
+			if err := merge("auth", cfg.Auths, fileCfg.Auths, f, authSources); err != nil {
+				return nil, err
+			}
+			if err := merge("module", cfg.Modules, fileCfg.Modules, f, moduleSources); err != nil {
 				return nil, err
 			}
+			if err := merge("target", cfg.Targets, fileCfg.Targets, f, targetSources); err != nil {
+				return nil, err
+			}
+			if fileCfg.Version != 0 {
+				cfg.Version = fileCfg.Version
+			}
+		}
+	}
+	cfg.Hash = hex.EncodeToString(hash.Sum(nil))
+	for name, module := range cfg.Modules {
+		module.Source = moduleSources[name]
+	}
+	if err := cfg.ResolveExtends(); err != nil {
+		return nil, err
+	}
+	for name, auth := range cfg.Auths {
+		if err := auth.resolveSecrets(); err != nil {
+			return nil, fmt.Errorf("error resolving secrets of auth %q: %w", name, err)
+		}
+		for _, fallback := range auth.Fallback {
+			if _, ok := cfg.Auths[fallback]; !ok {
+				return nil, fmt.Errorf("unknown fallback auth %q of auth %q", fallback, name)
+			}
+		}
+	}
+	for name, target := range cfg.Targets {
+		if target.Auth != "" {
+			if _, ok := cfg.Auths[target.Auth]; !ok {
+				return nil, fmt.Errorf("unknown auth %q of target %q", target.Auth, name)
+			}
+		}
+		for _, module := range target.Modules {
+			if _, ok := cfg.Modules[module]; !ok {
+				return nil, fmt.Errorf("unknown module %q of target %q", module, name)
+			}
 		}
 	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
 
+// overridable is implemented by definitions which can replace one of the
+// same name from an earlier file.
+type overridable interface {
+	overrides() bool
+}
+
+func (c *Auth) overrides() bool   { return c.Override }
+func (c *Module) overrides() bool { return c.Override }
+func (c *Target) overrides() bool { return c.Override }
+
+// merge adds the definitions loaded from file to dst. A name defined in an
+// earlier file is an error, unless the new definition is marked override.
+func merge[T overridable](kind string, dst, src map[string]T, file string, sources map[string]string) error {
+	names := make([]string, 0, len(src))
+	for name := range src {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if prev, ok := sources[name]; ok && !src[name].overrides() {
+			return fmt.Errorf("%s %q is defined in both %s and %s, set override: true in %s to replace it", kind, name, prev, file, file)
+		}
+		dst[name] = src[name]
+		sources[name] = file
+	}
+	return nil
+}
+
+// AuthNames returns the auths to try for the given auth names: either the
+// names themselves, or a single name followed by its fallback auths.
+func (c *Config) AuthNames(names []string) []string {
+	if len(names) == 1 {
+		if auth, ok := c.Auths[names[0]]; ok {
+			names = append(names, auth.Fallback...)
+		}
+	}
+	seen := make(map[string]bool, len(names))
+	unique := make([]string, 0, len(names))
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			unique = append(unique, name)
+		}
+	}
+	return unique
+}
+
 var (
 	defaultRetries = 3
 
@@ -75,11 +212,9 @@ var (
 		Version:       2,
 	}
 	DefaultWalkParams = WalkParams{
-		MaxRepetitions:          25,
-		Retries:                 &defaultRetries,
-		Timeout:                 time.Second * 5,
-		UseUnconnectedUDPSocket: false,
-		AllowNonIncreasingOIDs:  false,
+		MaxRepetitions: 25,
+		Retries:        &defaultRetries,
+		Timeout:        time.Second * 5,
 	}
 	DefaultModule = Module{
 		WalkParams: DefaultWalkParams,
@@ -87,36 +222,151 @@ var (
 	DefaultRegexpExtract = RegexpExtract{
 		Value: "$1",
 	}
+	DefaultRelabelConfig = RelabelConfig{
+		Separator:   ";",
+		Regex:       MustNewRegexp("(.*)"),
+		Replacement: "$1",
+		Action:      RelabelReplace,
+	}
 )
 
 // Config for the snmp_exporter.
 type Config struct {
 	Auths   map[string]*Auth   `yaml:"auths,omitempty"`
 	Modules map[string]*Module `yaml:"modules,omitempty"`
+	Targets map[string]*Target `yaml:"targets,omitempty"`
 	Version int                `yaml:"version,omitempty"`
+	// The SHA-256 of the contents of the files the configuration was loaded
+	// from, set by LoadFile.
+	Hash string `yaml:"-"`
 }
 
 type WalkParams struct {
 	MaxRepetitions          uint32        `yaml:"max_repetitions,omitempty"`
 	Retries                 *int          `yaml:"retries,omitempty"`
 	Timeout                 time.Duration `yaml:"timeout,omitempty"`
-	UseUnconnectedUDPSocket bool          `yaml:"use_unconnected_udp_socket,omitempty"`
-	AllowNonIncreasingOIDs  bool          `yaml:"allow_nonincreasing_oids,omitempty"`
+	UseUnconnectedUDPSocket *bool         `yaml:"use_unconnected_udp_socket,omitempty"`
+	AllowNonIncreasingOIDs  *bool         `yaml:"allow_nonincreasing_oids,omitempty"`
+	WalkConcurrency         int           `yaml:"walk_concurrency,omitempty"`
+	AdaptiveMaxRepetitions  *bool         `yaml:"adaptive_max_repetitions,omitempty"`
+	// How long the max repetitions learned for a target are kept after its
+	// last walk, defaults to an hour.
+	AdaptiveMaxRepetitionsTTL time.Duration `yaml:"adaptive_max_repetitions_ttl,omitempty"`
 }
 
 type Module struct {
+	// Modules whose walks, gets, metrics, filters and computed metrics this
+	// one includes.
+	Extends []string `yaml:"extends,omitempty"`
 	// A list of OIDs.
-	Walk       []string        `yaml:"walk,omitempty"`
-	Get        []string        `yaml:"get,omitempty"`
-	Metrics    []*Metric       `yaml:"metrics"`
-	WalkParams WalkParams      `yaml:",inline"`
-	Filters    []DynamicFilter `yaml:"filters,omitempty"`
+	Walk        []string        `yaml:"walk,omitempty"`
+	Get         []string        `yaml:"get,omitempty"`
+	Metrics     []*Metric       `yaml:"metrics"`
+	WalkParams  WalkParams      `yaml:",inline"`
+	Filters     []DynamicFilter `yaml:"filters,omitempty"`
+	OnError     string          `yaml:"on_error,omitempty"`
+	MinInterval time.Duration   `yaml:"min_interval,omitempty"`
+	// Metrics calculated from the values of other metrics.
+	ComputedMetrics []*ComputedMetric `yaml:"computed_metrics,omitempty"`
+	// Rules applied to the samples of the metrics, in order.
+	MetricRelabelConfigs []*RelabelConfig `yaml:"metric_relabel_configs,omitempty"`
+	// Replace a module of the same name from an earlier file.
+	Override bool `yaml:"override,omitempty"`
+	// The file the module was loaded from, set by LoadFile. It isn't part
+	// of the configuration format.
+	Source string `yaml:"-"`
+}
+
+// Override returns the walk parameters with the fields set in o replacing
+// those of p.
+func (p WalkParams) Override(o WalkParams) WalkParams {
+	if o.MaxRepetitions != 0 {
+		p.MaxRepetitions = o.MaxRepetitions
+	}
+	if o.Retries != nil {
+		p.Retries = o.Retries
+	}
+	if o.Timeout != 0 {
+		p.Timeout = o.Timeout
+	}
+	if o.WalkConcurrency != 0 {
+		p.WalkConcurrency = o.WalkConcurrency
+	}
+	if o.AdaptiveMaxRepetitionsTTL != 0 {
+		p.AdaptiveMaxRepetitionsTTL = o.AdaptiveMaxRepetitionsTTL
+	}
+	if o.UseUnconnectedUDPSocket != nil {
+		p.UseUnconnectedUDPSocket = o.UseUnconnectedUDPSocket
+	}
+	if o.AllowNonIncreasingOIDs != nil {
+		p.AllowNonIncreasingOIDs = o.AllowNonIncreasingOIDs
+	}
+	if o.AdaptiveMaxRepetitions != nil {
+		p.AdaptiveMaxRepetitions = o.AdaptiveMaxRepetitions
+	}
+	return p
+}
+
+// Enabled returns whether an optional flag of the walk parameters is set
+// and true.
+func Enabled(flag *bool) bool {
+	return flag != nil && *flag
+}
+
+// Target is a device in the target inventory, scraped by its name.
+type Target struct {
+	// The address of the device, in the format of the target parameter.
+	Address string   `yaml:"address"`
+	Auth    string   `yaml:"auth,omitempty"`
+	Modules []string `yaml:"modules,omitempty"`
+	// Walk parameters overriding those of the modules.
+	WalkParams WalkParams `yaml:"walk_params,omitempty"`
+	// Labels added to all metrics of the target.
+	Labels map[string]string `yaml:"labels,omitempty"`
+	// Replace a target of the same name from an earlier file.
+	Override bool `yaml:"override,omitempty"`
+}
+
+var labelNameRE = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+func (c *Target) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Target
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.Address == "" {
+		return fmt.Errorf("target address is missing")
+	}
+	if c.WalkParams.WalkConcurrency < 0 {
+		return fmt.Errorf("walk_concurrency must not be negative. Got: %d", c.WalkParams.WalkConcurrency)
+	}
+	for name := range c.Labels {
+		if !labelNameRE.MatchString(name) {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+	}
+	return nil
 }
 
 func (c *Module) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	*c = DefaultModule
 	type plain Module
-	return unmarshal((*plain)(c))
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+
+	switch c.OnError {
+	case "", OnErrorFail, OnErrorPartial:
+	default:
+		return fmt.Errorf("on_error must be one of %s or %s. Got: %s", OnErrorFail, OnErrorPartial, c.OnError)
+	}
+	if c.MinInterval < 0 {
+		return fmt.Errorf("min_interval must not be negative. Got: %s", c.MinInterval)
+	}
+	if c.WalkParams.WalkConcurrency < 0 {
+		return fmt.Errorf("walk_concurrency must not be negative. Got: %d", c.WalkParams.WalkConcurrency)
+	}
+	return nil
 }
 
 // ConfigureSNMP sets the various version and auth settings.
@@ -214,6 +464,17 @@ type Metric struct {
 	Scale          float64                    `yaml:"scale,omitempty"`
 }
 
+// ComputedMetric is a metric whose value is an expression over the values
+// of other metrics of the module in the same scrape. Values of metrics with
+// indexes are matched on the index, metrics without indexes apply to all.
+type ComputedMetric struct {
+	Name string `yaml:"name"`
+	// gauge or counter, gauge if empty.
+	Type string `yaml:"type,omitempty"`
+	Help string `yaml:"help,omitempty"`
+	Expr Expr   `yaml:"expr"`
+}
+
 type Index struct {
 	Labelname  string         `yaml:"labelname"`
 	Type       string         `yaml:"type"`
@@ -257,7 +518,15 @@ type Auth struct {
 	PrivProtocol  string `yaml:"priv_protocol,omitempty"`
 	PrivPassword  Secret `yaml:"priv_password,omitempty"`
 	ContextName   string `yaml:"context_name,omitempty"`
-	Version       int    `yaml:"version,omitempty"`
+	// Files to read the secrets from when the configuration is loaded.
+	CommunityFile    string `yaml:"community_file,omitempty"`
+	PasswordFile     string `yaml:"password_file,omitempty"`
+	PrivPasswordFile string `yaml:"priv_password_file,omitempty"`
+	Version          int    `yaml:"version,omitempty"`
+	// Auths to try in order if this one doesn't work for a target.
+	Fallback []string `yaml:"fallback,omitempty"`
+	// Replace an auth of the same name from an earlier file.
+	Override bool `yaml:"override,omitempty"`
 }
 
 func (c *Auth) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -270,10 +539,23 @@ func (c *Auth) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if c.Version < 1 || c.Version > 3 {
 		return fmt.Errorf("SNMP version must be 1, 2 or 3. Got: %d", c.Version)
 	}
+	// The community has a default, so only a different one conflicts.
+	if c.CommunityFile != "" {
+		if c.Community != DefaultAuth.Community {
+			return fmt.Errorf("at most one of community and community_file must be set")
+		}
+		c.Community = ""
+	}
+	if c.PasswordFile != "" && c.Password != "" {
+		return fmt.Errorf("at most one of password and password_file must be set")
+	}
+	if c.PrivPasswordFile != "" && c.PrivPassword != "" {
+		return fmt.Errorf("at most one of priv_password and priv_password_file must be set")
+	}
 	if c.Version == 3 {
 		switch c.SecurityLevel {
 		case "authPriv":
-			if c.PrivPassword == "" {
+			if c.PrivPassword == "" && c.PrivPasswordFile == "" {
 				return fmt.Errorf("priv password is missing, required for SNMPv3 with priv")
 			}
 			if c.PrivProtocol != "DES" && c.PrivProtocol != "AES" && c.PrivProtocol != "AES192" && c.PrivProtocol != "AES192C" && c.PrivProtocol != "AES256" && c.PrivProtocol != "AES256C" {
@@ -281,7 +563,7 @@ func (c *Auth) UnmarshalYAML(unmarshal func(interface{}) error) error {
 			}
 			fallthrough
 		case "authNoPriv":
-			if c.Password == "" {
+			if c.Password == "" && c.PasswordFile == "" {
 				return fmt.Errorf("auth password is missing, required for SNMPv3 with auth")
 			}
 			if c.AuthProtocol != "MD5" && c.AuthProtocol != "SHA" && c.AuthProtocol != "SHA224" && c.AuthProtocol != "SHA256" && c.AuthProtocol != "SHA384" && c.AuthProtocol != "SHA512" {
@@ -299,6 +581,76 @@ func (c *Auth) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
+var envRE = regexp.MustCompile(`\$\{([a-zA-Z_][a-zA-Z0-9_]*)\}`)
+
+// expandEnv replaces ${VAR} in s with the value of the environment
+// variable VAR, which must be set.
+func expandEnv(s string) (string, error) {
+	var err error
+	s = envRE.ReplaceAllStringFunc(s, func(m string) string {
+		name := envRE.FindStringSubmatch(m)[1]
+		v, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable %s is not set", name)
+		}
+		return v
+	})
+	return s, err
+}
+
+// SecretFiles returns the sorted paths of the files the secrets of the auths
+// are read from.
+func (c *Config) SecretFiles() []string {
+	seen := map[string]bool{}
+	files := []string{}
+	for _, auth := range c.Auths {
+		for _, f := range []string{auth.CommunityFile, auth.PasswordFile, auth.PrivPasswordFile} {
+			if f != "" && !seen[f] {
+				seen[f] = true
+				files = append(files, f)
+			}
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// resolveSecrets reads the secrets of the auth from their files, and
+// expands environment variables in the username and inline secrets.
+func (c *Auth) resolveSecrets() error {
+	username, err := expandEnv(c.Username)
+	if err != nil {
+		return err
+	}
+	c.Username = username
+	for _, secret := range []struct {
+		value *Secret
+		file  string
+	}{
+		{&c.Community, c.CommunityFile},
+		{&c.Password, c.PasswordFile},
+		{&c.PrivPassword, c.PrivPasswordFile},
+	} {
+		if secret.file != "" {
+			content, err := os.ReadFile(secret.file)
+			if err != nil {
+				return err
+			}
+			*secret.value = Secret(strings.TrimRight(string(content), "\r\n"))
+			if *secret.value == "" {
+				return fmt.Errorf("secret file %s is empty", secret.file)
+			}
+			continue
+		}
+		v, err := expandEnv(string(*secret.value))
+		if err != nil {
+			return err
+		}
+		*secret.value = Secret(v)
+	}
+	return nil
+}
+
 type RegexpExtract struct {
 	Value string `yaml:"value"`
 	Regex Regexp `yaml:"regex"`
@@ -310,6 +662,88 @@ func (c *RegexpExtract) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return unmarshal((*plain)(c))
 }
 
+// RelabelConfig is a rule changing the metric name and labels of samples,
+// like Prometheus' metric_relabel_configs. The metric name is the
+// __name__ label.
+type RelabelConfig struct {
+	SourceLabels []string `yaml:"source_labels,flow,omitempty"`
+	Separator    string   `yaml:"separator,omitempty"`
+	Regex        Regexp   `yaml:"regex,omitempty"`
+	Modulus      uint64   `yaml:"modulus,omitempty"`
+	TargetLabel  string   `yaml:"target_label,omitempty"`
+	Replacement  string   `yaml:"replacement,omitempty"`
+	Action       string   `yaml:"action,omitempty"`
+}
+
+func (c *RelabelConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultRelabelConfig
+	type plain RelabelConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.Regex.Regexp == nil {
+		c.Regex = MustNewRegexp("")
+	}
+	for _, label := range c.SourceLabels {
+		if !labelNameRE.MatchString(label) {
+			return fmt.Errorf("invalid source label %q", label)
+		}
+	}
+	switch c.Action {
+	case RelabelReplace, RelabelHashMod:
+		if c.TargetLabel == "" {
+			return fmt.Errorf("relabel action %s requires target_label", c.Action)
+		}
+		if c.Action == RelabelReplace && !validTemplate(c.TargetLabel, labelNameRE) {
+			return fmt.Errorf("invalid target label %q", c.TargetLabel)
+		}
+		if c.Action == RelabelReplace && c.TargetLabel == MetricNameLabel && c.Replacement != "" && !validTemplate(c.Replacement, metricNameRE) {
+			return fmt.Errorf("invalid metric name %q", c.Replacement)
+		}
+		if c.Action == RelabelHashMod {
+			if c.Modulus == 0 {
+				return fmt.Errorf("relabel action hashmod requires a non-zero modulus")
+			}
+			if !labelNameRE.MatchString(c.TargetLabel) {
+				return fmt.Errorf("invalid target label %q", c.TargetLabel)
+			}
+		}
+	case RelabelKeep, RelabelDrop:
+		if len(c.SourceLabels) == 0 {
+			return fmt.Errorf("relabel action %s requires source_labels", c.Action)
+		}
+	case RelabelLabelMap, RelabelLabelRename:
+		if !validTemplate(c.Replacement, labelNameRE) {
+			return fmt.Errorf("invalid replacement label name %q", c.Replacement)
+		}
+	case RelabelLabelDrop, RelabelLabelKeep:
+	default:
+		return fmt.Errorf("unknown relabel action %q", c.Action)
+	}
+	return nil
+}
+
+var templateRefRE = regexp.MustCompile(`\$(\$|\{\w+\}|\w+)`)
+
+// validTemplate returns whether the template of a relabel config matches re
+// with its references to capture groups expanded to a letter. Whether the
+// values of the groups fit can only be known when relabelling.
+func validTemplate(template string, re *regexp.Regexp) bool {
+	expanded := templateRefRE.ReplaceAllStringFunc(template, func(ref string) string {
+		if ref == "$$" {
+			return "$"
+		}
+		return "x"
+	})
+	return re.MatchString(expanded)
+}
+
+// MustNewRegexp returns a Regexp anchored like those from YAML, panicking
+// if it doesn't compile.
+func MustNewRegexp(s string) Regexp {
+	return Regexp{regexp.MustCompile("^(?:" + s + ")$")}
+}
+
 // Regexp encapsulates a regexp.Regexp and makes it YAML marshalable.
 type Regexp struct {
 	*regexp.Regexp
@@ -0,0 +1,275 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Expr is an arithmetic expression over the values of metrics, with
+// numbers, metric names, +, -, *, / and parentheses. It is YAML marshalable
+// as its source.
+type Expr struct {
+	src  string
+	root exprNode
+}
+
+type exprNode interface {
+	eval(value func(name string) (float64, bool)) (float64, bool)
+}
+
+type exprNumber float64
+
+func (n exprNumber) eval(func(string) (float64, bool)) (float64, bool) {
+	return float64(n), true
+}
+
+type exprMetric string
+
+func (m exprMetric) eval(value func(string) (float64, bool)) (float64, bool) {
+	return value(string(m))
+}
+
+type exprNeg struct {
+	x exprNode
+}
+
+func (n exprNeg) eval(value func(string) (float64, bool)) (float64, bool) {
+	x, ok := n.x.eval(value)
+	return -x, ok
+}
+
+type exprBinary struct {
+	op          byte
+	left, right exprNode
+}
+
+func (b exprBinary) eval(value func(string) (float64, bool)) (float64, bool) {
+	l, ok := b.left.eval(value)
+	if !ok {
+		return 0, false
+	}
+	r, ok := b.right.eval(value)
+	if !ok {
+		return 0, false
+	}
+	switch b.op {
+	case '+':
+		return l + r, true
+	case '-':
+		return l - r, true
+	case '*':
+		return l * r, true
+	default:
+		return l / r, true
+	}
+}
+
+// ParseExpr parses an expression.
+func ParseExpr(s string) (Expr, error) {
+	p := &exprParser{src: s}
+	p.next()
+	root, err := p.parseSum()
+	if err != nil {
+		return Expr{}, err
+	}
+	if p.tok != "" {
+		return Expr{}, fmt.Errorf("unexpected %q at position %d of expression %q", p.tok, p.tokPos, s)
+	}
+	return Expr{src: s, root: root}, nil
+}
+
+// Eval evaluates the expression with the values of the metrics. It returns
+// false if a metric has no value.
+func (e Expr) Eval(value func(name string) (float64, bool)) (float64, bool) {
+	if e.root == nil {
+		return 0, false
+	}
+	return e.root.eval(value)
+}
+
+// Metrics returns the names of the metrics in the expression, in the order
+// they first appear.
+func (e Expr) Metrics() []string {
+	var names []string
+	seen := map[string]bool{}
+	var walk func(n exprNode)
+	walk = func(n exprNode) {
+		switch n := n.(type) {
+		case exprMetric:
+			if !seen[string(n)] {
+				seen[string(n)] = true
+				names = append(names, string(n))
+			}
+		case exprNeg:
+			walk(n.x)
+		case exprBinary:
+			walk(n.left)
+			walk(n.right)
+		}
+	}
+	walk(e.root)
+	return names
+}
+
+func (e Expr) String() string {
+	return e.src
+}
+
+// MarshalYAML implements the yaml.Marshaler interface.
+func (e Expr) MarshalYAML() (interface{}, error) {
+	return e.src, nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (e *Expr) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	expr, err := ParseExpr(s)
+	if err != nil {
+		return err
+	}
+	*e = expr
+	return nil
+}
+
+// exprParser is a recursive descent parser of expressions.
+type exprParser struct {
+	src    string
+	pos    int
+	tok    string
+	tokPos int
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// next reads the next token, which is empty at the end.
+func (p *exprParser) next() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t' || p.src[p.pos] == '\n') {
+		p.pos++
+	}
+	p.tokPos = p.pos
+	if p.pos == len(p.src) {
+		p.tok = ""
+		return
+	}
+	c := p.src[p.pos]
+	end := p.pos + 1
+	switch {
+	case isIdentStart(c):
+		for end < len(p.src) && (isIdentStart(p.src[end]) || isDigit(p.src[end])) {
+			end++
+		}
+	case isDigit(c) || c == '.':
+		for end < len(p.src) && (isDigit(p.src[end]) || p.src[end] == '.') {
+			end++
+		}
+		if end < len(p.src) && (p.src[end] == 'e' || p.src[end] == 'E') {
+			end++
+			if end < len(p.src) && (p.src[end] == '+' || p.src[end] == '-') {
+				end++
+			}
+			for end < len(p.src) && isDigit(p.src[end]) {
+				end++
+			}
+		}
+	}
+	p.tok = p.src[p.pos:end]
+	p.pos = end
+}
+
+func (p *exprParser) parseSum() (exprNode, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == "+" || p.tok == "-" {
+		op := p.tok[0]
+		p.next()
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = exprBinary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseProduct() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == "*" || p.tok == "/" {
+		op := p.tok[0]
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = exprBinary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.tok == "-" {
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return exprNeg{x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok, pos := p.tok, p.tokPos
+	switch {
+	case tok == "":
+		return nil, fmt.Errorf("unexpected end of expression %q", p.src)
+	case tok == "(":
+		p.next()
+		x, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, fmt.Errorf("missing ) at position %d of expression %q", p.tokPos, p.src)
+		}
+		p.next()
+		return x, nil
+	case isIdentStart(tok[0]):
+		p.next()
+		return exprMetric(tok), nil
+	case isDigit(tok[0]) || tok[0] == '.':
+		v, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d of expression %q", tok, pos, p.src)
+		}
+		p.next()
+		return exprNumber(v), nil
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d of expression %q", tok, pos, p.src)
+}
@@ -0,0 +1,166 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ResolveExtends merges the modules each module extends into it, so that
+// every module holds all the walks, gets, metrics, filters and computed
+// metrics it scrapes.
+func (c *Config) ResolveExtends() error {
+	names := make([]string, 0, len(c.Modules))
+	for name := range c.Modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	resolved := make(map[string]bool, len(c.Modules))
+	var resolve func(name string, path []string) error
+	resolve = func(name string, path []string) error {
+		if resolved[name] {
+			return nil
+		}
+		for _, p := range path {
+			if p == name {
+				return fmt.Errorf("module %q extends itself: %s", name, strings.Join(append(path, name), " -> "))
+			}
+		}
+		module := c.Modules[name]
+		modules := make([]*Module, 0, len(module.Extends)+1)
+		for _, base := range module.Extends {
+			if _, ok := c.Modules[base]; !ok {
+				return fmt.Errorf("module %q extends unknown module %q", name, base)
+			}
+			if err := resolve(base, append(path, name)); err != nil {
+				return err
+			}
+			modules = append(modules, c.Modules[base])
+		}
+		if len(modules) > 0 {
+			*module = *MergeModules(append(modules, module)...)
+		}
+		resolved[name] = true
+		return nil
+	}
+	for _, name := range names {
+		if err := resolve(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MergeModules merges the walks, gets, metrics, filters and computed metrics
+// of the modules, with later modules overriding earlier ones:
+//   - walks and gets are combined, each OID is kept once and OIDs within a
+//     walked subtree are left out,
+//   - metrics replace all earlier metrics of the same name,
+//   - filters replace all earlier filters on the same OID,
+//   - computed metrics replace earlier computed metrics of the same name.
+//
+// All other settings, such as the walk parameters, are those of the last
+// module.
+func MergeModules(modules ...*Module) *Module {
+	out := *modules[len(modules)-1]
+	out.Walk, out.Get, out.Metrics, out.Filters, out.ComputedMetrics = nil, nil, nil, nil, nil
+	for _, m := range modules {
+		out.Walk = appendUnique(out.Walk, m.Walk)
+		out.Get = appendUnique(out.Get, m.Get)
+
+		names := make(map[string]bool, len(m.Metrics))
+		for _, metric := range m.Metrics {
+			names[metric.Name] = true
+		}
+		metrics := make([]*Metric, 0, len(out.Metrics)+len(m.Metrics))
+		for _, metric := range out.Metrics {
+			if !names[metric.Name] {
+				metrics = append(metrics, metric)
+			}
+		}
+		out.Metrics = append(metrics, m.Metrics...)
+
+		oids := make(map[string]bool, len(m.Filters))
+		for _, filter := range m.Filters {
+			oids[filter.Oid] = true
+		}
+		var filters []DynamicFilter
+		for _, filter := range out.Filters {
+			if !oids[filter.Oid] {
+				filters = append(filters, filter)
+			}
+		}
+		out.Filters = append(filters, m.Filters...)
+
+		names = make(map[string]bool, len(m.ComputedMetrics))
+		for _, cm := range m.ComputedMetrics {
+			names[cm.Name] = true
+		}
+		var computed []*ComputedMetric
+		for _, cm := range out.ComputedMetrics {
+			if !names[cm.Name] {
+				computed = append(computed, cm)
+			}
+		}
+		out.ComputedMetrics = append(computed, m.ComputedMetrics...)
+	}
+	out.Walk = withoutCovered(out.Walk, out.Walk, out.Filters)
+	out.Get = withoutCovered(out.Get, out.Walk, out.Filters)
+	return &out
+}
+
+// withoutCovered removes the OIDs within the subtree of another OID of
+// walks, which are walked anyway and would return duplicate samples. OIDs
+// that are the target of one of the filters are kept, as the filter only
+// applies to walks of exactly its targets.
+func withoutCovered(oids, walks []string, filters []DynamicFilter) []string {
+	targets := map[string]bool{}
+	for _, filter := range filters {
+		for _, target := range filter.Targets {
+			targets[target] = true
+		}
+	}
+	var kept []string
+	for _, oid := range oids {
+		covered := false
+		for _, walk := range walks {
+			if walk != oid && strings.HasPrefix(oid+".", walk+".") && !targets[oid] {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			kept = append(kept, oid)
+		}
+	}
+	return kept
+}
+
+func appendUnique(dst, src []string) []string {
+	for _, s := range src {
+		found := false
+		for _, d := range dst {
+			if d == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			dst = append(dst, s)
+		}
+	}
+	return dst
+}
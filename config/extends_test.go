@@ -0,0 +1,128 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWithoutCovered(t *testing.T) {
+	cases := []struct {
+		name    string
+		oids    []string
+		walks   []string
+		filters []DynamicFilter
+		want    []string
+	}{
+		{
+			name:  "nothing covered",
+			oids:  []string{"1.3.6.1.2.1.2", "1.3.6.1.2.1.25"},
+			walks: []string{"1.3.6.1.2.1.2", "1.3.6.1.2.1.25"},
+			want:  []string{"1.3.6.1.2.1.2", "1.3.6.1.2.1.25"},
+		},
+		{
+			name:  "within a walked subtree",
+			oids:  []string{"1.3.6.1.2.1.2", "1.3.6.1.2.1.2.2.1.10", "1.3.6.1.2.1.1.3.0"},
+			walks: []string{"1.3.6.1.2.1.2"},
+			want:  []string{"1.3.6.1.2.1.2", "1.3.6.1.2.1.1.3.0"},
+		},
+		{
+			name:  "sharing only a prefix",
+			oids:  []string{"1.3.6.1.2.1.20", "1.3.6.1.2.1.2"},
+			walks: []string{"1.3.6.1.2.1.2"},
+			want:  []string{"1.3.6.1.2.1.20", "1.3.6.1.2.1.2"},
+		},
+		{
+			name:  "target of a filter",
+			oids:  []string{"1.3.6.1.2.1.2", "1.3.6.1.2.1.2.2.1.10", "1.3.6.1.2.1.2.2.1.16"},
+			walks: []string{"1.3.6.1.2.1.2"},
+			filters: []DynamicFilter{
+				{Oid: "1.3.6.1.2.1.2.2.1.8", Targets: []string{"1.3.6.1.2.1.2.2.1.10"}, Values: []string{"1"}},
+			},
+			want: []string{"1.3.6.1.2.1.2", "1.3.6.1.2.1.2.2.1.10"},
+		},
+		{
+			name:  "only the filter OID",
+			oids:  []string{"1.3.6.1.2.1.2", "1.3.6.1.2.1.2.2.1.8"},
+			walks: []string{"1.3.6.1.2.1.2"},
+			filters: []DynamicFilter{
+				{Oid: "1.3.6.1.2.1.2.2.1.8", Targets: []string{"1.3.6.1.2.1.2.2.1.10"}, Values: []string{"1"}},
+			},
+			want: []string{"1.3.6.1.2.1.2"},
+		},
+	}
+	for _, c := range cases {
+		if got := withoutCovered(c.oids, c.walks, c.filters); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: want %v, got %v", c.name, c.want, got)
+		}
+	}
+}
+
+func TestMergeModulesFilters(t *testing.T) {
+	base := &Module{
+		Walk: []string{"1.3.6.1.2.1.2"},
+		Filters: []DynamicFilter{
+			{Oid: "1.3.6.1.2.1.2.2.1.8", Targets: []string{"1.3.6.1.2.1.2.2.1.2"}, Values: []string{"1"}},
+		},
+	}
+	cases := []struct {
+		name        string
+		module      *Module
+		wantWalk    []string
+		wantFilters []DynamicFilter
+	}{
+		{
+			name:        "inherited filter",
+			module:      &Module{},
+			wantWalk:    []string{"1.3.6.1.2.1.2"},
+			wantFilters: base.Filters,
+		},
+		{
+			name: "filter with other targets on the same OID",
+			module: &Module{
+				Walk: []string{"1.3.6.1.2.1.2.2.1.10"},
+				Filters: []DynamicFilter{
+					{Oid: "1.3.6.1.2.1.2.2.1.8", Targets: []string{"1.3.6.1.2.1.2.2.1.10"}, Values: []string{"1"}},
+				},
+			},
+			wantWalk: []string{"1.3.6.1.2.1.2", "1.3.6.1.2.1.2.2.1.10"},
+			wantFilters: []DynamicFilter{
+				{Oid: "1.3.6.1.2.1.2.2.1.8", Targets: []string{"1.3.6.1.2.1.2.2.1.10"}, Values: []string{"1"}},
+			},
+		},
+		{
+			name: "filter on another OID",
+			module: &Module{
+				Filters: []DynamicFilter{
+					{Oid: "1.3.6.1.2.1.2.2.1.7", Targets: []string{"1.3.6.1.2.1.2.2.1.2"}, Values: []string{"1"}},
+				},
+			},
+			wantWalk: []string{"1.3.6.1.2.1.2"},
+			wantFilters: []DynamicFilter{
+				{Oid: "1.3.6.1.2.1.2.2.1.8", Targets: []string{"1.3.6.1.2.1.2.2.1.2"}, Values: []string{"1"}},
+				{Oid: "1.3.6.1.2.1.2.2.1.7", Targets: []string{"1.3.6.1.2.1.2.2.1.2"}, Values: []string{"1"}},
+			},
+		},
+	}
+	for _, c := range cases {
+		merged := MergeModules(base, c.module)
+		if !reflect.DeepEqual(merged.Walk, c.wantWalk) {
+			t.Errorf("%s: want walk %v, got %v", c.name, c.wantWalk, merged.Walk)
+		}
+		if !reflect.DeepEqual(merged.Filters, c.wantFilters) {
+			t.Errorf("%s: want filters %v, got %v", c.name, c.wantFilters, merged.Filters)
+		}
+	}
+}
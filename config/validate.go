@@ -0,0 +1,306 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Types the exporter can convert index OIDs, and octet string values, of.
+var indexTypes = map[string]bool{
+	"Integer32":              true,
+	"Integer":                true,
+	"gauge":                  true,
+	"counter":                true,
+	"PhysAddress48":          true,
+	"OctetString":            true,
+	"DisplayString":          true,
+	"InetAddressIPv4":        true,
+	"InetAddressIPv6":        true,
+	"EnumAsInfo":             true,
+	"InetAddress":            true,
+	"InetAddressMissingSize": true,
+	"LldpPortId":             true,
+}
+
+// Metric types with their own handling, all others are converted like
+// index types.
+var valueTypes = map[string]bool{
+	MetricTypeGauge:          true,
+	MetricTypeCounter:        true,
+	MetricTypeFloat:          true,
+	MetricTypeDouble:         true,
+	MetricTypeDateAndTime:    true,
+	MetricTypeEnumAsInfo:     true,
+	MetricTypeEnumAsStateSet: true,
+	MetricTypeBits:           true,
+}
+
+var (
+	metricNameRE   = regexp.MustCompile("^[a-zA-Z_:][a-zA-Z0-9_:]*$")
+	metricSuffixRE = regexp.MustCompile("^[a-zA-Z0-9_:]*$")
+	oidRE          = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*$`)
+)
+
+// validate checks the metric definitions of all modules, so that problems
+// are found when the configuration is loaded rather than when scraping. All
+// problems found are returned.
+func (c *Config) validate() error {
+	names := make([]string, 0, len(c.Modules))
+	for name := range c.Modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var errs []error
+	for _, name := range names {
+		for _, err := range c.Modules[name].validate() {
+			errs = append(errs, fmt.Errorf("module %q: %w", name, err))
+		}
+	}
+	targets := make([]string, 0, len(c.Targets))
+	for name := range c.Targets {
+		targets = append(targets, name)
+	}
+	sort.Strings(targets)
+	for _, name := range targets {
+		for _, err := range c.validateTargetLabels(c.Targets[name]) {
+			errs = append(errs, fmt.Errorf("target %q: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateTargetLabels checks that the labels of the target don't clash with
+// labels the samples of its modules have, which would fail its scrapes.
+func (c *Config) validateTargetLabels(t *Target) []error {
+	if len(t.Labels) == 0 {
+		return nil
+	}
+	modules := t.Modules
+	if len(modules) == 0 {
+		// The module scraped if none is given.
+		modules = []string{"if_mib"}
+	}
+	labels := make([]string, 0, len(t.Labels))
+	for label := range t.Labels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	var errs []error
+	for _, name := range modules {
+		module, ok := c.Modules[name]
+		if !ok {
+			continue
+		}
+		moduleLabels := module.sampleLabels()
+		for _, label := range labels {
+			if moduleLabels[label] {
+				errs = append(errs, fmt.Errorf("label %q clashes with a label of module %q", label, name))
+			}
+		}
+	}
+	return errs
+}
+
+// sampleLabels returns the names of the labels samples of the module can
+// have, including those of the samples about the scrape.
+func (m *Module) sampleLabels() map[string]bool {
+	labels := map[string]bool{"module": true, "oid": true, "reason": true, "auth": true}
+	for _, metric := range m.Metrics {
+		for _, index := range metric.Indexes {
+			labels[index.Labelname] = true
+		}
+		for _, lookup := range metric.Lookups {
+			labels[lookup.Labelname] = true
+		}
+		switch metric.Type {
+		case MetricTypeEnumAsInfo, MetricTypeEnumAsStateSet, MetricTypeBits:
+			labels[metric.Name] = true
+		}
+	}
+	for _, rc := range m.MetricRelabelConfigs {
+		if rc.TargetLabel != "" && !strings.Contains(rc.TargetLabel, "$") {
+			labels[rc.TargetLabel] = true
+		}
+	}
+	return labels
+}
+
+func (m *Module) validate() []error {
+	var errs []error
+	// Label names and types of each metric name, which must agree.
+	seen := map[string]*Metric{}
+	for _, metric := range m.Metrics {
+		for _, err := range metric.validate() {
+			errs = append(errs, fmt.Errorf("metric %q: %w", metric.Name, err))
+		}
+		prev, ok := seen[metric.Name]
+		if !ok {
+			seen[metric.Name] = metric
+			continue
+		}
+		if prev.Type != metric.Type {
+			errs = append(errs, fmt.Errorf("metric %q: defined with both type %s and %s", metric.Name, prev.Type, metric.Type))
+		} else if a, b := prev.labelNames(), metric.labelNames(); a != b {
+			errs = append(errs, fmt.Errorf("metric %q: defined with both labels [%s] and [%s]", metric.Name, a, b))
+		}
+	}
+	computed := map[string]bool{}
+	for _, cm := range m.ComputedMetrics {
+		for _, err := range cm.validate(seen) {
+			errs = append(errs, fmt.Errorf("computed metric %q: %w", cm.Name, err))
+		}
+		if computed[cm.Name] {
+			errs = append(errs, fmt.Errorf("computed metric %q: defined more than once", cm.Name))
+		}
+		computed[cm.Name] = true
+	}
+	return errs
+}
+
+// validate checks the computed metric against the metrics of its module.
+func (c *ComputedMetric) validate(metrics map[string]*Metric) []error {
+	var errs []error
+	if !metricNameRE.MatchString(c.Name) {
+		errs = append(errs, fmt.Errorf("invalid metric name"))
+	}
+	if _, ok := metrics[c.Name]; ok {
+		errs = append(errs, fmt.Errorf("name is already used by a metric"))
+	}
+	if c.Type != "" && c.Type != MetricTypeGauge && c.Type != MetricTypeCounter {
+		errs = append(errs, fmt.Errorf("type must be gauge or counter, not %q", c.Type))
+	}
+	if c.Expr.root == nil {
+		return append(errs, fmt.Errorf("expr is missing"))
+	}
+	// The indexes of the metrics, which must be the same for all of them
+	// that have indexes to match their values.
+	var indexed, indexes string
+	for _, name := range c.Expr.Metrics() {
+		metric, ok := metrics[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("expr refers to %q, which is not a metric of the module", name))
+			continue
+		}
+		switch metric.Type {
+		case MetricTypeGauge, MetricTypeCounter, MetricTypeFloat, MetricTypeDouble:
+		default:
+			errs = append(errs, fmt.Errorf("expr refers to %q of type %s, which has no numeric value", name, metric.Type))
+			continue
+		}
+		if len(metric.Indexes) == 0 {
+			continue
+		}
+		if names := metric.indexNames(); indexed == "" {
+			indexed, indexes = name, names
+		} else if names != indexes {
+			errs = append(errs, fmt.Errorf("expr refers to %q with indexes [%s] and %q with indexes [%s], which can't be matched", indexed, indexes, name, names))
+		}
+	}
+	return errs
+}
+
+// indexNames returns the label names of the indexes of the metric.
+func (m *Metric) indexNames() string {
+	names := make([]string, 0, len(m.Indexes))
+	for _, index := range m.Indexes {
+		names = append(names, index.Labelname)
+	}
+	return strings.Join(names, ",")
+}
+
+// labelNames returns the sorted label names from the indexes and lookups of
+// the metric.
+func (m *Metric) labelNames() string {
+	labels := map[string]bool{}
+	for _, index := range m.Indexes {
+		labels[index.Labelname] = true
+	}
+	for _, lookup := range m.Lookups {
+		labels[lookup.Labelname] = len(lookup.Labels) > 0
+	}
+	names := []string{}
+	for name, ok := range labels {
+		if ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func (m *Metric) validate() []error {
+	var errs []error
+	if !metricNameRE.MatchString(m.Name) {
+		errs = append(errs, fmt.Errorf("invalid metric name"))
+	}
+	if !oidRE.MatchString(m.Oid) {
+		errs = append(errs, fmt.Errorf("invalid OID %q", m.Oid))
+	}
+	if !valueTypes[m.Type] && !indexTypes[m.Type] {
+		errs = append(errs, fmt.Errorf("unknown type %q", m.Type))
+	}
+
+	// Labels whose OIDs lookups can refer to.
+	labels := map[string]bool{}
+	for _, index := range m.Indexes {
+		if !labelNameRE.MatchString(index.Labelname) {
+			errs = append(errs, fmt.Errorf("invalid index label name %q", index.Labelname))
+		}
+		if !indexTypes[index.Type] {
+			errs = append(errs, fmt.Errorf("unknown type %q of index %q", index.Type, index.Labelname))
+		}
+		if index.FixedSize < 0 {
+			errs = append(errs, fmt.Errorf("negative fixed_size of index %q", index.Labelname))
+		}
+		labels[index.Labelname] = true
+	}
+	for _, lookup := range m.Lookups {
+		if !labelNameRE.MatchString(lookup.Labelname) {
+			errs = append(errs, fmt.Errorf("invalid lookup label name %q", lookup.Labelname))
+		}
+		if len(lookup.Labels) == 0 {
+			// The lookup only removes the label.
+			continue
+		}
+		if !oidRE.MatchString(lookup.Oid) {
+			errs = append(errs, fmt.Errorf("invalid OID %q of lookup %q", lookup.Oid, lookup.Labelname))
+		}
+		if lookup.Type != "" && lookup.Type != MetricTypeBits && !indexTypes[lookup.Type] {
+			errs = append(errs, fmt.Errorf("unknown type %q of lookup %q", lookup.Type, lookup.Labelname))
+		}
+		for _, label := range lookup.Labels {
+			if !labels[label] {
+				errs = append(errs, fmt.Errorf("lookup %q refers to label %q, which is neither an index nor an earlier lookup", lookup.Labelname, label))
+			}
+		}
+		labels[lookup.Labelname] = true
+	}
+
+	suffixes := make([]string, 0, len(m.RegexpExtracts))
+	for suffix := range m.RegexpExtracts {
+		suffixes = append(suffixes, suffix)
+	}
+	sort.Strings(suffixes)
+	for _, suffix := range suffixes {
+		if !metricSuffixRE.MatchString(suffix) {
+			errs = append(errs, fmt.Errorf("invalid regex_extracts name %q, %q is not a valid metric name", suffix, m.Name+suffix))
+		}
+	}
+	return errs
+}
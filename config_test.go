@@ -14,10 +14,14 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	yaml "gopkg.in/yaml.v2"
+
+	"github.com/shatteredsilicon/snmp_exporter/config"
 )
 
 func TestHideConfigSecrets(t *testing.T) {
@@ -67,3 +71,280 @@ func TestLoadMultipleConfigs(t *testing.T) {
 		t.Errorf("Error marshaling config: %v", err)
 	}
 }
+
+func TestLoadConfigOnError(t *testing.T) {
+	sc := &SafeConfig{}
+	err := sc.ReloadConfig([]string{"testdata/snmp-on-error.yml"})
+	if err != nil {
+		t.Fatalf("Error loading config %v: %v", "testdata/snmp-on-error.yml", err)
+	}
+	sc.RLock()
+	defer sc.RUnlock()
+	if got := sc.C.Modules["partial"].OnError; got != "partial" {
+		t.Errorf("Expected on_error partial, got %q", got)
+	}
+
+	err = yaml.UnmarshalStrict([]byte("on_error: ignore\n"), &config.Module{})
+	if err == nil {
+		t.Error("Expected error for invalid on_error value")
+	}
+}
+
+func TestLoadConfigInvalidMetrics(t *testing.T) {
+	sc := &SafeConfig{}
+	err := sc.ReloadConfig([]string{"testdata/snmp-invalid.yml"})
+	if err == nil {
+		t.Fatal("Expected error loading invalid config")
+	}
+	// All problems are reported, with their module and metric.
+	for _, want := range []string{
+		`module "invalid": metric "ifInOctets": unknown type "Integer33" of index "ifIndex"`,
+		`module "invalid": metric "ifOutOctets": lookup "ifDescr" refers to label "ifName"`,
+		`module "invalid": metric "ifInOctets": defined with both type counter and gauge`,
+		`module "invalid": computed metric "ifOctets": expr refers to "ifHCInOctets", which is not a metric of the module`,
+		`module "invalid": computed metric "ifOutOctets": name is already used by a metric`,
+		`module "invalid": computed metric "ifOutOctets": type must be gauge or counter, not "summary"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to contain %q, got %q", want, err)
+		}
+	}
+	if sc.C != nil {
+		t.Error("Expected invalid config not to be applied")
+	}
+
+	file := filepath.Join(t.TempDir(), "snmp.yml")
+	content := "modules:\n  m:\n    computed_metrics:\n    - name: x\n      expr: (a + b\n"
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err = sc.ReloadConfig([]string{file})
+	if err == nil || !strings.Contains(err.Error(), `missing ) at position 6 of expression "(a + b"`) {
+		t.Errorf("Expected expression syntax error, got %v", err)
+	}
+}
+
+func TestLoadConfigDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.yml": "auths:\n  shared:\n    community: a\nmodules:\n  if_mib:\n    walk: [1.3.6.1.2.1.2]\n",
+		"b.yml": "auths:\n  shared:\n    community: b\n",
+		"c.yml": "auths:\n  shared:\n    community: c\n    override: true\nmodules:\n  other:\n    walk: [1.3.6.1.2.1.1]\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, b, c := filepath.Join(dir, "a.yml"), filepath.Join(dir, "b.yml"), filepath.Join(dir, "c.yml")
+
+	sc := &SafeConfig{}
+	err := sc.ReloadConfig([]string{filepath.Join(dir, "*.yml")})
+	if err == nil {
+		t.Fatal("Expected error loading duplicate auth")
+	}
+	if !strings.Contains(err.Error(), `auth "shared"`) || !strings.Contains(err.Error(), a) || !strings.Contains(err.Error(), b) {
+		t.Errorf("Expected error naming the auth and both files, got %q", err)
+	}
+
+	// A file matching several globs is loaded once.
+	if err := sc.ReloadConfig([]string{a, c, filepath.Join(dir, "[ac].yml")}); err != nil {
+		t.Fatalf("Error loading configs: %v", err)
+	}
+	if got := sc.C.Auths["shared"].Community; got != "c" {
+		t.Errorf("Expected overriding community c, got %q", got)
+	}
+	if got := sc.C.Modules["if_mib"].Source; got != a {
+		t.Errorf("Expected module source %s, got %q", a, got)
+	}
+	if got := sc.C.Modules["other"].Source; got != c {
+		t.Errorf("Expected module source %s, got %q", c, got)
+	}
+	// The source is shown on /config, but can't be set.
+	out, err := marshalConfig(sc.C)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "#   other: "+c+"\n") {
+		t.Errorf("Expected module source %s in marshalled config:\n%s", c, out)
+	}
+	if err := os.WriteFile(b, []byte("modules:\n  sourced:\n    source: other.yml\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := sc.ReloadConfig([]string{b}); err == nil {
+		t.Error("Expected error loading a module with a source")
+	}
+}
+
+func TestMarshalConfigRoundTrip(t *testing.T) {
+	sc := &SafeConfig{}
+	if err := sc.ReloadConfig([]string{"snmp.yml", "testdata/snmp-sim.yml"}); err != nil {
+		t.Fatalf("Error loading configs: %v", err)
+	}
+	config.DoNotHideSecrets = true
+	defer func() { config.DoNotHideSecrets = false }()
+	out, err := marshalConfig(sc.C)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"#   sim: testdata/snmp-sim.yml\n", "#   ssm_mib: snmp.yml\n"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("Expected %q in marshalled config", want)
+		}
+	}
+
+	// The marshalled configuration loads as the same configuration.
+	file := filepath.Join(t.TempDir(), "snmp.yml")
+	if err := os.WriteFile(file, out, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loaded := &SafeConfig{}
+	if err := loaded.ReloadConfig([]string{file}); err != nil {
+		t.Fatalf("Error loading marshalled config: %v", err)
+	}
+	want, err := yaml.Marshal(sc.C)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := yaml.Marshal(loaded.C)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("Expected the reloaded config to marshal the same")
+	}
+	if got := loaded.C.Modules["sim"].Source; got != file {
+		t.Errorf("Expected module source %s, got %q", file, got)
+	}
+}
+
+func TestLoadConfigExtends(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "snmp.yml")
+	content := `modules:
+  base_if:
+    walk: [1.3.6.1.2.1.2.2.1.10, 1.3.6.1.2.1.2.2.1.16]
+    metrics:
+    - name: ifInOctets
+      oid: 1.3.6.1.2.1.2.2.1.10
+      type: counter
+    - name: ifOutOctets
+      oid: 1.3.6.1.2.1.2.2.1.16
+      type: counter
+  base_host:
+    get: [1.3.6.1.2.1.25.1.1.0]
+    metrics:
+    - name: hrSystemUptime
+      oid: 1.3.6.1.2.1.25.1.1
+      type: gauge
+  vendor:
+    extends: [base_if, base_host]
+    walk: [1.3.6.1.2.1.2.2.1.10, 1.3.6.1.4.1.9]
+    timeout: 10s
+    metrics:
+    - name: ifOutOctets
+      oid: 1.3.6.1.2.1.2.2.1.16
+      type: gauge
+`
+	if err := os.WriteFile(configFile, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sc := &SafeConfig{}
+	if err := sc.ReloadConfig([]string{configFile}); err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	module := sc.C.Modules["vendor"]
+	if got, want := strings.Join(module.Walk, " "), "1.3.6.1.2.1.2.2.1.10 1.3.6.1.2.1.2.2.1.16 1.3.6.1.4.1.9"; got != want {
+		t.Errorf("Expected walk %q, got %q", want, got)
+	}
+	if got := strings.Join(module.Get, " "); got != "1.3.6.1.2.1.25.1.1.0" {
+		t.Errorf("Expected inherited get, got %q", got)
+	}
+	types := []string{}
+	for _, metric := range module.Metrics {
+		types = append(types, metric.Name+":"+metric.Type)
+	}
+	if got, want := strings.Join(types, " "), "ifInOctets:counter hrSystemUptime:gauge ifOutOctets:gauge"; got != want {
+		t.Errorf("Expected metrics %q, got %q", want, got)
+	}
+	if module.WalkParams.Timeout.String() != "10s" {
+		t.Errorf("Expected the module's own timeout, got %s", module.WalkParams.Timeout)
+	}
+
+	// OIDs within a subtree another module walks are walked once.
+	merged := config.MergeModules(
+		&config.Module{Walk: []string{"1.3.6.1.2.1.2"}, Get: []string{"1.3.6.1.2.1.1.3.0"}},
+		&config.Module{Walk: []string{"1.3.6.1.2.1.2.2.1.10", "1.3.6.1.2.1.25", "1.3.6.1.2.1.20"}, Get: []string{"1.3.6.1.2.1.25.1.1.0", "1.3.6.1.2.1.1.5.0"}},
+	)
+	if got, want := strings.Join(merged.Walk, " "), "1.3.6.1.2.1.2 1.3.6.1.2.1.25 1.3.6.1.2.1.20"; got != want {
+		t.Errorf("Expected walk %q, got %q", want, got)
+	}
+	if got, want := strings.Join(merged.Get, " "), "1.3.6.1.2.1.1.3.0 1.3.6.1.2.1.1.5.0"; got != want {
+		t.Errorf("Expected get %q, got %q", want, got)
+	}
+
+	for _, content := range []string{
+		"modules:\n  a:\n    extends: [b]\n  b:\n    extends: [a]\n",
+		"modules:\n  a:\n    extends: [unknown]\n",
+	} {
+		if err := os.WriteFile(configFile, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := sc.ReloadConfig([]string{configFile}); err == nil {
+			t.Errorf("Expected error loading %q", content)
+		}
+	}
+}
+
+func TestLoadConfigTargets(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "snmp.yml")
+	content := `modules:
+  m:
+    walk: [1.3.6.1.2.1.2]
+    adaptive_max_repetitions: true
+    allow_nonincreasing_oids: true
+    metrics:
+    - name: ifInOctets
+      oid: 1.3.6.1.2.1.2.2.1.10
+      type: counter
+      indexes:
+      - labelname: ifIndex
+        type: gauge
+targets:
+  sw:
+    address: 192.0.0.8
+    modules: [m]
+    walk_params:
+      adaptive_max_repetitions: false
+    labels:
+      site: lab
+`
+	if err := os.WriteFile(configFile, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sc := &SafeConfig{}
+	if err := sc.ReloadConfig([]string{configFile}); err != nil {
+		t.Fatal(err)
+	}
+	// Flags set by the target replace those of the module, even to turn
+	// them off, and unset ones are kept.
+	params := sc.C.Modules["m"].WalkParams.Override(sc.C.Targets["sw"].WalkParams)
+	if config.Enabled(params.AdaptiveMaxRepetitions) {
+		t.Error("Expected adaptive max repetitions turned off by the target")
+	}
+	if !config.Enabled(params.AllowNonIncreasingOIDs) {
+		t.Error("Expected non-increasing OIDs allowed by the module")
+	}
+
+	for label, want := range map[string]string{
+		"ifIndex": `target "sw": label "ifIndex" clashes with a label of module "m"`,
+		"module":  `target "sw": label "module" clashes with a label of module "m"`,
+	} {
+		if err := os.WriteFile(configFile, []byte(strings.Replace(content, "site: lab", label+": x", 1)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		err := sc.ReloadConfig([]string{configFile})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error %q, got %v", want, err)
+		}
+	}
+}
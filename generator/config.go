@@ -16,6 +16,7 @@ package main
 import (
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/shatteredsilicon/snmp_exporter/config"
 )
@@ -51,11 +52,17 @@ func (c *MetricOverrides) UnmarshalYAML(unmarshal func(interface{}) error) error
 }
 
 type ModuleConfig struct {
-	Walk       []string                   `yaml:"walk"`
-	Lookups    []*Lookup                  `yaml:"lookups"`
-	WalkParams config.WalkParams          `yaml:",inline"`
-	Overrides  map[string]MetricOverrides `yaml:"overrides"`
-	Filters    config.Filters             `yaml:"filters,omitempty"`
+	Extends     []string                   `yaml:"extends,omitempty"`
+	Walk        []string                   `yaml:"walk"`
+	Lookups     []*Lookup                  `yaml:"lookups"`
+	WalkParams  config.WalkParams          `yaml:",inline"`
+	Overrides   map[string]MetricOverrides `yaml:"overrides"`
+	Filters     config.Filters             `yaml:"filters,omitempty"`
+	OnError     string                     `yaml:"on_error,omitempty"`
+	MinInterval time.Duration              `yaml:"min_interval,omitempty"`
+
+	ComputedMetrics      []*config.ComputedMetric `yaml:"computed_metrics,omitempty"`
+	MetricRelabelConfigs []*config.RelabelConfig  `yaml:"metric_relabel_configs,omitempty"`
 }
 
 // UnmarshalYAML implements the yaml.Unmarshaler interface.
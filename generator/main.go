@@ -17,6 +17,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 
 	"github.com/alecthomas/kingpin/v2"
@@ -65,8 +66,16 @@ func generateConfig(nodes *Node, nameToNode map[string]*Node, logger log.Logger)
 		}
 		outputConfig.Modules[name] = out
 		outputConfig.Modules[name].WalkParams = m.WalkParams
+		outputConfig.Modules[name].OnError = m.OnError
+		outputConfig.Modules[name].MinInterval = m.MinInterval
+		outputConfig.Modules[name].Extends = m.Extends
+		outputConfig.Modules[name].ComputedMetrics = m.ComputedMetrics
+		outputConfig.Modules[name].MetricRelabelConfigs = m.MetricRelabelConfigs
 		level.Info(logger).Log("msg", "Generated metrics", "module", name, "metrics", len(outputConfig.Modules[name].Metrics))
 	}
+	if err := trimExtends(outputConfig.Modules); err != nil {
+		return err
+	}
 
 	config.DoNotHideSecrets = true
 	out, err := yaml.Marshal(outputConfig)
@@ -94,6 +103,80 @@ func generateConfig(nodes *Node, nameToNode map[string]*Node, logger log.Logger)
 	return nil
 }
 
+// trimExtends removes the walks, gets, metrics and filters that modules get
+// from the modules they extend, to keep the output small.
+func trimExtends(modules map[string]*config.Module) error {
+	resolved := &config.Config{Modules: make(map[string]*config.Module, len(modules))}
+	for name, m := range modules {
+		c := *m
+		resolved.Modules[name] = &c
+	}
+	if err := resolved.ResolveExtends(); err != nil {
+		return err
+	}
+	for _, m := range modules {
+		if len(m.Extends) == 0 {
+			continue
+		}
+		bases := make([]*config.Module, len(m.Extends))
+		for i, base := range m.Extends {
+			bases[i] = resolved.Modules[base]
+		}
+		inherited := config.MergeModules(bases...)
+		m.Walk = withoutOids(m.Walk, inherited.Walk)
+		m.Get = withoutOids(m.Get, inherited.Get)
+
+		// Metrics and filters are only left out if they are the same as all
+		// those they would replace.
+		inheritedMetrics := map[string][]*config.Metric{}
+		for _, metric := range inherited.Metrics {
+			inheritedMetrics[metric.Name] = append(inheritedMetrics[metric.Name], metric)
+		}
+		ownMetrics := map[string][]*config.Metric{}
+		for _, metric := range m.Metrics {
+			ownMetrics[metric.Name] = append(ownMetrics[metric.Name], metric)
+		}
+		metrics := []*config.Metric{}
+		for _, metric := range m.Metrics {
+			if !reflect.DeepEqual(ownMetrics[metric.Name], inheritedMetrics[metric.Name]) {
+				metrics = append(metrics, metric)
+			}
+		}
+		m.Metrics = metrics
+
+		inheritedFilters := map[string][]config.DynamicFilter{}
+		for _, filter := range inherited.Filters {
+			inheritedFilters[filter.Oid] = append(inheritedFilters[filter.Oid], filter)
+		}
+		ownFilters := map[string][]config.DynamicFilter{}
+		for _, filter := range m.Filters {
+			ownFilters[filter.Oid] = append(ownFilters[filter.Oid], filter)
+		}
+		var filters []config.DynamicFilter
+		for _, filter := range m.Filters {
+			if !reflect.DeepEqual(ownFilters[filter.Oid], inheritedFilters[filter.Oid]) {
+				filters = append(filters, filter)
+			}
+		}
+		m.Filters = filters
+	}
+	return nil
+}
+
+func withoutOids(oids, remove []string) []string {
+	removed := make(map[string]bool, len(remove))
+	for _, oid := range remove {
+		removed[oid] = true
+	}
+	var out []string
+	for _, oid := range oids {
+		if !removed[oid] {
+			out = append(out, oid)
+		}
+	}
+	return out
+}
+
 var (
 	failOnParseErrors  = kingpin.Flag("fail-on-parse-errors", "Exit with a non-zero status if there are MIB parsing errors").Default("false").Bool()
 	snmpMIBOpts        = kingpin.Flag("snmp.mibopts", "Toggle various defaults controlling MIB parsing, see snmpwalk --help").String()
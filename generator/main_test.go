@@ -0,0 +1,116 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/shatteredsilicon/snmp_exporter/config"
+)
+
+func TestTrimExtends(t *testing.T) {
+	ifDescr := &config.Metric{Name: "ifDescr", Oid: "1.3.6.1.2.1.2.2.1.2", Type: "DisplayString"}
+	ifInOctets := &config.Metric{Name: "ifInOctets", Oid: "1.3.6.1.2.1.2.2.1.10", Type: "counter"}
+	upFilter := config.DynamicFilter{Oid: "1.3.6.1.2.1.2.2.1.8", Targets: []string{"1.3.6.1.2.1.2.2.1.10"}, Values: []string{"1"}}
+	base := func() *config.Module {
+		return &config.Module{
+			Walk:    []string{"1.3.6.1.2.1.2.2.1.2", "1.3.6.1.2.1.2.2.1.10"},
+			Get:     []string{"1.3.6.1.2.1.1.3.0"},
+			Metrics: []*config.Metric{ifDescr, ifInOctets},
+			Filters: []config.DynamicFilter{upFilter},
+		}
+	}
+	cases := []struct {
+		name   string
+		module *config.Module
+		want   *config.Module
+	}{
+		{
+			name:   "without extends",
+			module: base(),
+			want:   base(),
+		},
+		{
+			name: "everything inherited",
+			module: &config.Module{
+				Extends: []string{"base"},
+				Walk:    []string{"1.3.6.1.2.1.2.2.1.2", "1.3.6.1.2.1.2.2.1.10", "1.3.6.1.2.1.25"},
+				Get:     []string{"1.3.6.1.2.1.1.3.0", "1.3.6.1.2.1.1.5.0"},
+				Metrics: []*config.Metric{ifDescr, ifInOctets},
+				Filters: []config.DynamicFilter{upFilter},
+			},
+			want: &config.Module{
+				Extends: []string{"base"},
+				Walk:    []string{"1.3.6.1.2.1.25"},
+				Get:     []string{"1.3.6.1.2.1.1.5.0"},
+				Metrics: []*config.Metric{},
+			},
+		},
+		{
+			name: "metric overridden",
+			module: &config.Module{
+				Extends: []string{"base"},
+				Metrics: []*config.Metric{ifDescr, {Name: "ifInOctets", Oid: "1.3.6.1.2.1.2.2.1.10", Type: "gauge"}},
+			},
+			want: &config.Module{
+				Extends: []string{"base"},
+				Metrics: []*config.Metric{{Name: "ifInOctets", Oid: "1.3.6.1.2.1.2.2.1.10", Type: "gauge"}},
+			},
+		},
+		{
+			name: "filter with other values",
+			module: &config.Module{
+				Extends: []string{"base"},
+				Metrics: []*config.Metric{},
+				Filters: []config.DynamicFilter{{Oid: "1.3.6.1.2.1.2.2.1.8", Targets: []string{"1.3.6.1.2.1.2.2.1.10"}, Values: []string{"2"}}},
+			},
+			want: &config.Module{
+				Extends: []string{"base"},
+				Metrics: []*config.Metric{},
+				Filters: []config.DynamicFilter{{Oid: "1.3.6.1.2.1.2.2.1.8", Targets: []string{"1.3.6.1.2.1.2.2.1.10"}, Values: []string{"2"}}},
+			},
+		},
+		{
+			name: "filter with other targets",
+			module: &config.Module{
+				Extends: []string{"base"},
+				Metrics: []*config.Metric{},
+				Filters: []config.DynamicFilter{{Oid: "1.3.6.1.2.1.2.2.1.8", Targets: []string{"1.3.6.1.2.1.2.2.1.2"}, Values: []string{"1"}}},
+			},
+			want: &config.Module{
+				Extends: []string{"base"},
+				Metrics: []*config.Metric{},
+				Filters: []config.DynamicFilter{{Oid: "1.3.6.1.2.1.2.2.1.8", Targets: []string{"1.3.6.1.2.1.2.2.1.2"}, Values: []string{"1"}}},
+			},
+		},
+	}
+	for _, c := range cases {
+		modules := map[string]*config.Module{"base": base(), "module": c.module}
+		if err := trimExtends(modules); err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if !reflect.DeepEqual(modules["module"], c.want) {
+			t.Errorf("%s: want %+v, got %+v", c.name, c.want, modules["module"])
+		}
+		if !reflect.DeepEqual(modules["base"], base()) {
+			t.Errorf("%s: expected the extended module to be unchanged, got %+v", c.name, modules["base"])
+		}
+	}
+
+	modules := map[string]*config.Module{"module": {Extends: []string{"unknown"}}}
+	if err := trimExtends(modules); err == nil {
+		t.Error("expected an error for an unknown module")
+	}
+}
@@ -14,14 +14,20 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/go-kit/log"
@@ -45,15 +51,25 @@ const (
 )
 
 var (
-	configFile  = kingpin.Flag("config.file", "Path to configuration file.").Default("snmp.yml").Strings()
-	dryRun      = kingpin.Flag("dry-run", "Only verify configuration is valid and exit.").Default("false").Bool()
-	concurrency = kingpin.Flag("snmp.module-concurrency", "The number of modules to fetch concurrently per scrape").Default("1").Int()
-	metricsPath = kingpin.Flag(
+	configFile    = kingpin.Flag("config.file", "Path to configuration file.").Default("snmp.yml").Strings()
+	watchInterval = kingpin.Flag("config.watch-interval", "How often to check the configuration files for changes, including files newly matching a glob. Changed files are reloaded once they have been unchanged for --config.watch-debounce. 0 disables watching.").Default("0s").Duration()
+	watchDebounce = kingpin.Flag("config.watch-debounce", "How long changed configuration files must be unchanged before they are reloaded.").Default("5s").Duration()
+	dryRun        = kingpin.Flag("dry-run", "Only verify configuration is valid and exit.").Default("false").Bool()
+	concurrency   = kingpin.Flag("snmp.module-concurrency", "The number of modules to fetch concurrently per scrape").Default("1").Int()
+	maxScrapes    = kingpin.Flag("snmp.max-concurrent-scrapes", "Maximum number of scrapes in flight at once. 0 means no limit.").Default("0").Int()
+	maxQueued     = kingpin.Flag("snmp.max-queued-scrapes", "Maximum number of scrapes waiting for one of the concurrent scrape slots. Further scrapes are rejected.").Default("100").Int()
+	timeoutOffset = kingpin.Flag("snmp.scrape-timeout-offset", "Offset to subtract from the Prometheus scrape timeout when deriving the scrape deadline.").Default("500ms").Duration()
+	metricsPath   = kingpin.Flag(
 		"web.telemetry-path",
 		"Path under which to expose metrics.",
 	).Default("/metrics").String()
 	toolkitFlags = webflag.AddFlags(kingpin.CommandLine, ":9116")
 
+	runCommand     = kingpin.Command("run", "Run the exporter.").Default()
+	migrateCommand = kingpin.Command("migrate-config", "Convert an snmp.yml or generator.yml from before v0.23.0, with auth settings in the modules, to separate auths and modules.")
+	migrateInput   = migrateCommand.Arg("input", "Path to the old configuration file.").Required().String()
+	migrateOutput  = migrateCommand.Flag("output", "Path to write the converted configuration file to, - for standard output.").Short('o').Default("-").String()
+
 	// Metrics about the SNMP exporter itself.
 	snmpRequestErrors = promauto.NewCounter(
 		prometheus.CounterOpts{
@@ -62,6 +78,36 @@ var (
 			Help:      "Errors in requests to the SNMP exporter",
 		},
 	)
+	snmpConfigLastReloadSuccessful = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "config_last_reload_successful",
+			Help:      "Whether the last configuration reload attempt was successful.",
+		},
+	)
+	snmpConfigLastReloadSuccessTimestamp = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "config_last_reload_success_timestamp_seconds",
+			Help:      "Timestamp of the last successful configuration reload.",
+		},
+	)
+	snmpConfigInfo = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "config_info",
+			Help:      "The SHA-256 hash of the contents of the loaded configuration files.",
+		},
+		[]string{"hash"},
+	)
+	snmpScrapesRejected = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "scrapes_rejected_total",
+			Help:      "Scrapes rejected because the scrape queue was full, or that timed out or were canceled waiting in it.",
+		},
+		[]string{"reason"},
+	)
 	snmpCollectionDuration = promauto.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: namespace,
@@ -74,16 +120,132 @@ var (
 		C: &config.Config{},
 	}
 	reloadCh chan chan error
+	limiter  *scrapeLimiter
 )
 
 const (
-	proberPath = "/snmp"
-	configPath = "/config"
+	proberPath   = "/snmp"
+	authTestPath = "/snmp/auth-test"
+	configPath   = "/config"
+	sdPath       = "/sd"
 )
 
+// scrapeLimiter caps the number of scrapes in flight, with a bounded
+// queue of scrapes waiting for a free slot.
+type scrapeLimiter struct {
+	slots    chan struct{}
+	mu       sync.Mutex
+	queued   int
+	maxQueue int
+}
+
+func newScrapeLimiter(max, maxQueue int) *scrapeLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &scrapeLimiter{
+		slots:    make(chan struct{}, max),
+		maxQueue: maxQueue,
+	}
+}
+
+var errScrapeQueueFull = errors.New("scrape queue full")
+
+// acquire waits for a free slot. It returns errScrapeQueueFull if the queue
+// is full, or the error of the context if it's done before a slot became
+// free.
+func (l *scrapeLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	l.mu.Lock()
+	if l.queued >= l.maxQueue {
+		l.mu.Unlock()
+		return errScrapeQueueFull
+	}
+	l.queued++
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.queued--
+		l.mu.Unlock()
+	}()
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *scrapeLimiter) release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
+
+// rejectScrape answers a request that didn't get a slot of the limiter.
+// Requests whose deadline passed or that were canceled while queued are
+// counted apart from those rejected by a full queue.
+func rejectScrape(w http.ResponseWriter, logger log.Logger, err error) {
+	reason, msg := "queue_full", "Too many scrapes in flight"
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		reason, msg = "timeout", "Scrape timed out waiting for a free slot"
+	case errors.Is(err, context.Canceled):
+		reason, msg = "canceled", "Scrape canceled waiting for a free slot"
+	}
+	level.Debug(logger).Log("msg", "Rejecting scrape", "reason", reason)
+	http.Error(w, msg, http.StatusServiceUnavailable)
+	snmpScrapesRejected.WithLabelValues(reason).Inc()
+}
+
+// getScrapeTimeout returns the time left for a scrape, derived from the
+// scrape timeout Prometheus sends with each request. It returns 0 if the
+// request has no scrape timeout.
+func getScrapeTimeout(r *http.Request, offset time.Duration) (time.Duration, error) {
+	v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if v == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse timeout from Prometheus header: %s", err)
+	}
+	if seconds <= 0 {
+		return 0, fmt.Errorf("invalid timeout from Prometheus header: %s", v)
+	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > offset {
+		timeout -= offset
+	}
+	return timeout, nil
+}
+
 func handler(w http.ResponseWriter, r *http.Request, logger log.Logger, exporterMetrics collector.Metrics) {
 	query := r.URL.Query()
 
+	timeout, err := getScrapeTimeout(r, *timeoutOffset)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		snmpRequestErrors.Inc()
+		return
+	}
+	ctx := r.Context()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
 	target := query.Get("target")
 	if len(query["target"]) != 1 || target == "" {
 		http.Error(w, "'target' parameter must be specified once", http.StatusBadRequest)
@@ -97,11 +259,35 @@ func handler(w http.ResponseWriter, r *http.Request, logger log.Logger, exporter
 		snmpRequestErrors.Inc()
 		return
 	}
+	queryModule := query["module"]
+
+	sc.RLock()
+	// Targets in the inventory are scraped at their address with their auth
+	// and modules, unless the parameters say otherwise.
+	address := target
+	var walkParams *config.WalkParams
+	var labels prometheus.Labels
+	if t, ok := sc.C.Targets[target]; ok {
+		address = t.Address
+		if authName == "" {
+			authName = t.Auth
+		}
+		if len(queryModule) == 0 {
+			queryModule = t.Modules
+		}
+		walkParams = &t.WalkParams
+		labels = t.Labels
+	}
 	if authName == "" {
 		authName = "public_v2"
 	}
+	var authNames []string
+	for _, a := range strings.Split(authName, ",") {
+		if a != "" {
+			authNames = append(authNames, a)
+		}
+	}
 
-	queryModule := query["module"]
 	if len(queryModule) == 0 {
 		queryModule = append(queryModule, "if_mib")
 	}
@@ -118,11 +304,20 @@ func handler(w http.ResponseWriter, r *http.Request, logger log.Logger, exporter
 			}
 		}
 	}
-	sc.RLock()
-	auth, authOk := sc.C.Auths[authName]
-	if !authOk {
+	var nauths []*collector.NamedAuth
+	for _, a := range sc.C.AuthNames(authNames) {
+		auth, authOk := sc.C.Auths[a]
+		if !authOk {
+			sc.RUnlock()
+			http.Error(w, fmt.Sprintf("Unknown auth '%s'", a), http.StatusBadRequest)
+			snmpRequestErrors.Inc()
+			return
+		}
+		nauths = append(nauths, collector.NewNamedAuth(a, auth))
+	}
+	if len(nauths) == 0 {
 		sc.RUnlock()
-		http.Error(w, fmt.Sprintf("Unknown auth '%s'", authName), http.StatusBadRequest)
+		http.Error(w, "'auth' parameter must name at least one auth", http.StatusBadRequest)
 		snmpRequestErrors.Inc()
 		return
 	}
@@ -135,18 +330,156 @@ func handler(w http.ResponseWriter, r *http.Request, logger log.Logger, exporter
 			snmpRequestErrors.Inc()
 			return
 		}
+		if walkParams != nil {
+			overridden := *module
+			overridden.WalkParams = module.WalkParams.Override(*walkParams)
+			module = &overridden
+		}
 		nmodules = append(nmodules, collector.NewNamedModule(m, module))
 	}
 	sc.RUnlock()
 	logger = log.With(logger, "auth", authName, "target", target)
+	if address != target {
+		logger = log.With(logger, "address", address)
+	}
+	if err := limiter.acquire(ctx); err != nil {
+		rejectScrape(w, logger, err)
+		return
+	}
+	defer limiter.release()
 	registry := prometheus.NewRegistry()
-	c := collector.New(r.Context(), target, authName, auth, nmodules, logger, exporterMetrics, *concurrency)
-	registry.MustRegister(c)
+	c := collector.New(ctx, address, nauths, nmodules, logger, exporterMetrics, *concurrency)
+	prometheus.WrapRegistererWith(labels, registry).MustRegister(c)
 	// Delegate http serving to Prometheus client library, which will call collector.Collect.
 	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
 	h.ServeHTTP(w, r)
 }
 
+// authTestHandler tries each of the configured auths, or those given in the
+// auth parameter, against the target with a get of sysObjectID, and reports
+// the results as JSON.
+func authTestHandler(w http.ResponseWriter, r *http.Request, logger log.Logger, exporterMetrics collector.Metrics) {
+	query := r.URL.Query()
+	target := query.Get("target")
+	if len(query["target"]) != 1 || target == "" {
+		http.Error(w, "'target' parameter must be specified once", http.StatusBadRequest)
+		snmpRequestErrors.Inc()
+		return
+	}
+	var authNames []string
+	for _, qa := range query["auth"] {
+		for _, a := range strings.Split(qa, ",") {
+			if a != "" {
+				authNames = append(authNames, a)
+			}
+		}
+	}
+
+	sc.RLock()
+	if len(authNames) == 0 {
+		for name := range sc.C.Auths {
+			authNames = append(authNames, name)
+		}
+		sort.Strings(authNames)
+	}
+	var nauths []*collector.NamedAuth
+	for _, a := range authNames {
+		auth, authOk := sc.C.Auths[a]
+		if !authOk {
+			sc.RUnlock()
+			http.Error(w, fmt.Sprintf("Unknown auth '%s'", a), http.StatusBadRequest)
+			snmpRequestErrors.Inc()
+			return
+		}
+		nauths = append(nauths, collector.NewNamedAuth(a, auth))
+	}
+	params := config.DefaultWalkParams
+	if m := query.Get("module"); m != "" {
+		module, moduleOk := sc.C.Modules[m]
+		if !moduleOk {
+			sc.RUnlock()
+			http.Error(w, fmt.Sprintf("Unknown module '%s'", m), http.StatusBadRequest)
+			snmpRequestErrors.Inc()
+			return
+		}
+		params = module.WalkParams
+	}
+	sc.RUnlock()
+
+	// The probes count as a scrape of the target, and their packets are
+	// paced like those of scrapes.
+	if err := limiter.acquire(r.Context()); err != nil {
+		rejectScrape(w, log.With(logger, "target", target), err)
+		return
+	}
+	defer limiter.release()
+	level.Debug(logger).Log("msg", "Testing auths", "target", target, "auths", strings.Join(authNames, ","))
+	results := make([]collector.AuthCheckResult, len(nauths))
+	wg := sync.WaitGroup{}
+	for i, auth := range nauths {
+		wg.Add(1)
+		go func(i int, auth *collector.NamedAuth) {
+			defer wg.Done()
+			results[i] = collector.CheckAuth(r.Context(), target, auth, params, exporterMetrics)
+		}(i, auth)
+	}
+	wg.Wait()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		Target  string                      `json:"target"`
+		Results []collector.AuthCheckResult `json:"results"`
+	}{target, results}); err != nil {
+		level.Error(logger).Log("msg", "Error encoding auth test results", "err", err)
+	}
+}
+
+// sdTargetGroup is a target group of Prometheus HTTP service discovery.
+type sdTargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// sdHandler serves the target inventory for Prometheus HTTP service
+// discovery. The target of each group is the exporter itself, as reached by
+// the request, with the scrape parameters in labels. Labels apply to all
+// targets of a group and the target parameter differs for every device, so
+// rather than one group per modules and auth, each device has its own group
+// and the groups are ordered by modules and auth.
+func sdHandler(w http.ResponseWriter, r *http.Request, logger log.Logger) {
+	sc.RLock()
+	groups := make([]sdTargetGroup, 0, len(sc.C.Targets))
+	for name, t := range sc.C.Targets {
+		labels := map[string]string{
+			"__param_target": name,
+			"instance":       name,
+		}
+		if len(t.Modules) > 0 {
+			labels["__param_module"] = strings.Join(t.Modules, ",")
+		}
+		if t.Auth != "" {
+			labels["__param_auth"] = t.Auth
+		}
+		groups = append(groups, sdTargetGroup{Targets: []string{r.Host}, Labels: labels})
+	}
+	sc.RUnlock()
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := groups[i].Labels, groups[j].Labels
+		if a["__param_module"] != b["__param_module"] {
+			return a["__param_module"] < b["__param_module"]
+		}
+		if a["__param_auth"] != b["__param_auth"] {
+			return a["__param_auth"] < b["__param_auth"]
+		}
+		return a["__param_target"] < b["__param_target"]
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(groups); err != nil {
+		level.Error(logger).Log("msg", "Error encoding service discovery targets", "err", err)
+	}
+}
+
 func updateConfiguration(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "POST":
@@ -167,11 +500,22 @@ type SafeConfig struct {
 
 func (sc *SafeConfig) ReloadConfig(configFile []string) (err error) {
 	conf, err := config.LoadFile(configFile)
+	if err == nil {
+		err = collector.LoadSSMMappings()
+	}
 	if err != nil {
+		snmpConfigLastReloadSuccessful.Set(0)
 		return err
 	}
 	sc.Lock()
 	sc.C = conf
+	snmpConfigLastReloadSuccessful.Set(1)
+	snmpConfigLastReloadSuccessTimestamp.SetToCurrentTime()
+	snmpConfigInfo.Reset()
+	snmpConfigInfo.WithLabelValues(conf.Hash).Set(1)
+	collector.ResetSessionPool()
+	collector.ResetScrapeCache()
+	collector.ResetAuthCache()
 	// Initialize metrics.
 	for module := range sc.C.Modules {
 		snmpCollectionDuration.WithLabelValues(module)
@@ -180,27 +524,68 @@ func (sc *SafeConfig) ReloadConfig(configFile []string) (err error) {
 	return nil
 }
 
+// marshalConfig marshals the configuration for the /config page. The file
+// each module was loaded from isn't part of the configuration format, so it
+// is listed in a comment after it, and the output can be loaded again.
+func marshalConfig(c *config.Config) ([]byte, error) {
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(c.Modules))
+	for name, module := range c.Modules {
+		if module.Source != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return out, nil
+	}
+	sort.Strings(names)
+	out = append(out, "# Files the modules were loaded from:\n"...)
+	for _, name := range names {
+		out = append(out, fmt.Sprintf("#   %s: %s\n", name, c.Modules[name].Source)...)
+	}
+	return out, nil
+}
+
 func main() {
 	promlogConfig := &promlog.Config{}
 	flag.AddFlags(kingpin.CommandLine, promlogConfig)
 	kingpin.Version(version.Print("snmp_exporter"))
 	kingpin.HelpFlag.Short('h')
-	kingpin.Parse()
+	command := kingpin.Parse()
 	logger := promlog.New(promlogConfig)
+	if command == migrateCommand.FullCommand() {
+		if err := runMigrateConfig(*migrateInput, *migrateOutput); err != nil {
+			level.Error(logger).Log("msg", "Error migrating config file", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
 	if *concurrency < 1 {
 		*concurrency = 1
 	}
+	limiter = newScrapeLimiter(*maxScrapes, *maxQueued)
 
 	level.Info(logger).Log("msg", "Starting snmp_exporter", "version", version.Info(), "concurrency", concurrency)
 	level.Info(logger).Log("build_context", version.BuildContext())
 
 	prometheus.MustRegister(version.NewCollector("snmp_exporter"))
+	promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "ssm_tracked_targets",
+			Help:      "Number of target, auth and module combinations with SSM state.",
+		},
+		func() float64 { return float64(collector.SSMTrackedTargets()) },
+	)
 
 	// Bail early if the config is bad.
 	err := sc.ReloadConfig(*configFile)
 	if err != nil {
 		level.Error(logger).Log("msg", "Error parsing config file", "err", err)
-		level.Error(logger).Log("msg", "Possible old config file, see https://github.com/prometheus/snmp_exporter/blob/main/auth-split-migration.md")
+		level.Error(logger).Log("msg", "Possible old config file, convert it with snmp_exporter migrate-config, see https://github.com/prometheus/snmp_exporter/blob/main/auth-split-migration.md")
 		os.Exit(1)
 	}
 
@@ -234,6 +619,19 @@ func main() {
 		}
 	}()
 
+	if *watchInterval > 0 {
+		watched := func() []string {
+			sc.RLock()
+			defer sc.RUnlock()
+			return watchedPaths(*configFile, collector.SSMMappingFile(), sc.C)
+		}
+		go watchConfig(context.Background(), watched, *watchInterval, *watchDebounce, logger, func() {
+			rc := make(chan error)
+			reloadCh <- rc
+			<-rc
+		})
+	}
+
 	buckets := prometheus.ExponentialBuckets(0.0001, 2, 15)
 	exporterMetrics := collector.Metrics{
 		SNMPCollectionDuration: snmpCollectionDuration,
@@ -266,6 +664,20 @@ func main() {
 				Help:      "Number of SNMP packet retries.",
 			},
 		),
+		SNMPSessionPoolHits: promauto.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "session_pool_hits_total",
+				Help:      "Number of scrapes that reused an idle SNMP session.",
+			},
+		),
+		SNMPSessionPoolMisses: promauto.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "session_pool_misses_total",
+				Help:      "Number of scrapes that had to open a new SNMP session.",
+			},
+		),
 	}
 
 	http.Handle(*metricsPath, promhttp.Handler()) // Normal metrics endpoint for SNMP exporter itself.
@@ -273,6 +685,14 @@ func main() {
 	http.HandleFunc(proberPath, func(w http.ResponseWriter, r *http.Request) {
 		handler(w, r, logger, exporterMetrics)
 	})
+	// Endpoint to find out which auths work for a target.
+	http.HandleFunc(authTestPath, func(w http.ResponseWriter, r *http.Request) {
+		authTestHandler(w, r, logger, exporterMetrics)
+	})
+	// Endpoint for Prometheus HTTP service discovery of the target inventory.
+	http.HandleFunc(sdPath, func(w http.ResponseWriter, r *http.Request) {
+		sdHandler(w, r, logger)
+	})
 	http.HandleFunc("/-/reload", updateConfiguration) // Endpoint to reload configuration.
 
 	if *metricsPath != "/" && *metricsPath != "" {
@@ -327,7 +747,7 @@ func main() {
 
 	http.HandleFunc(configPath, func(w http.ResponseWriter, r *http.Request) {
 		sc.RLock()
-		c, err := yaml.Marshal(sc.C)
+		c, err := marshalConfig(sc.C)
 		sc.RUnlock()
 		if err != nil {
 			level.Error(logger).Log("msg", "Error marshaling configuration", "err", err)
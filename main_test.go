@@ -0,0 +1,705 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/gosnmp/gosnmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/shatteredsilicon/snmp_exporter/collector"
+	"github.com/shatteredsilicon/snmp_exporter/config"
+	"github.com/shatteredsilicon/snmp_exporter/snmpsim"
+)
+
+func TestGetScrapeTimeout(t *testing.T) {
+	cases := []struct {
+		header    string
+		offset    time.Duration
+		timeout   time.Duration
+		shouldErr bool
+	}{
+		{header: "", offset: 500 * time.Millisecond, timeout: 0},
+		{header: "10", offset: 500 * time.Millisecond, timeout: 9500 * time.Millisecond},
+		{header: "2.5", offset: 0, timeout: 2500 * time.Millisecond},
+		{header: "0.2", offset: 500 * time.Millisecond, timeout: 200 * time.Millisecond},
+		{header: "0", offset: 500 * time.Millisecond, shouldErr: true},
+		{header: "ten", offset: 500 * time.Millisecond, shouldErr: true},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/snmp?target=localhost", nil)
+		if c.header != "" {
+			r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", c.header)
+		}
+		got, err := getScrapeTimeout(r, c.offset)
+		if c.shouldErr {
+			if err == nil {
+				t.Errorf("Was expecting error, but none returned for %q", c.header)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Error getting scrape timeout for %q: %v", c.header, err)
+			continue
+		}
+		if got != c.timeout {
+			t.Errorf("Bad scrape timeout for %q, got=%s, expected=%s", c.header, got, c.timeout)
+		}
+	}
+}
+
+func TestScrapeLimiter(t *testing.T) {
+	var l *scrapeLimiter
+	if err := l.acquire(context.Background()); err != nil {
+		t.Fatalf("Expected a disabled limiter to never reject, got %v", err)
+	}
+	l.release()
+
+	l = newScrapeLimiter(1, 1)
+	if err := l.acquire(context.Background()); err != nil {
+		t.Fatalf("Expected a free slot, got %v", err)
+	}
+
+	// One scrape may queue for the slot, a second is rejected.
+	acquired := make(chan error)
+	go func() {
+		acquired <- l.acquire(context.Background())
+	}()
+	for {
+		l.mu.Lock()
+		queued := l.queued
+		l.mu.Unlock()
+		if queued == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := l.acquire(context.Background()); err != errScrapeQueueFull {
+		t.Fatalf("Expected scrape to be rejected with a full queue, got %v", err)
+	}
+
+	l.release()
+	if err := <-acquired; err != nil {
+		t.Fatalf("Expected queued scrape to get the slot, got %v", err)
+	}
+
+	// A queued scrape whose deadline passes isn't rejected for a full queue.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.acquire(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected queued scrape to give up at its deadline, got %v", err)
+	}
+	l.release()
+
+	for _, test := range []struct {
+		err    error
+		reason string
+	}{
+		{err: errScrapeQueueFull, reason: "queue_full"},
+		{err: context.DeadlineExceeded, reason: "timeout"},
+		{err: context.Canceled, reason: "canceled"},
+	} {
+		before := testutil.ToFloat64(snmpScrapesRejected.WithLabelValues(test.reason))
+		w := httptest.NewRecorder()
+		rejectScrape(w, log.NewNopLogger(), test.err)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("Expected status 503 for %v, got %d", test.err, w.Code)
+		}
+		if got := testutil.ToFloat64(snmpScrapesRejected.WithLabelValues(test.reason)) - before; got != 1 {
+			t.Errorf("Expected %v to be counted as %s, got %v", test.err, test.reason, got)
+		}
+	}
+}
+
+func testExporterMetrics() collector.Metrics {
+	return collector.Metrics{
+		SNMPCollectionDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "collection_duration"}, []string{"module"}),
+		SNMPUnexpectedPduType:  prometheus.NewCounter(prometheus.CounterOpts{Name: "unexpected_pdu_type"}),
+		SNMPDuration:           prometheus.NewHistogram(prometheus.HistogramOpts{Name: "duration"}),
+		SNMPPackets:            prometheus.NewCounter(prometheus.CounterOpts{Name: "packets"}),
+		SNMPRetries:            prometheus.NewCounter(prometheus.CounterOpts{Name: "retries"}),
+		SNMPSessionPoolHits:    prometheus.NewCounter(prometheus.CounterOpts{Name: "hits"}),
+		SNMPSessionPoolMisses:  prometheus.NewCounter(prometheus.CounterOpts{Name: "misses"}),
+	}
+}
+
+func TestHandlerAgent(t *testing.T) {
+	// Apply the defaults of the flags, like how long auths are remembered.
+	if _, err := kingpin.CommandLine.Parse([]string{}); err != nil {
+		t.Fatal(err)
+	}
+	agent, err := snmpsim.Load("testdata/device.snmprec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := agent.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer agent.Close()
+
+	if err := sc.ReloadConfig([]string{"testdata/snmp-sim.yml"}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { sc.C = &config.Config{} }()
+
+	metrics := testExporterMetrics()
+	scrape := func(params ...string) (int, string) {
+		r := httptest.NewRequest("GET", "/snmp?module=sim&target="+agent.Addr()+strings.Join(params, ""), nil)
+		w := httptest.NewRecorder()
+		handler(w, r, log.NewNopLogger(), metrics)
+		return w.Code, w.Body.String()
+	}
+
+	code, body := scrape()
+	if code != http.StatusOK {
+		t.Fatalf("Unexpected status %d: %s", code, body)
+	}
+	for _, want := range []string{
+		`sysUpTime 123456`,
+		`node_network_receive_bytes{device="eth0",ifIndex="2"} 2000`,
+		`node_network_receive_bytes{device="lo",ifIndex="1"} 1000`,
+		`snmp_scrape_pdus_returned{module="sim"} 5`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in scrape output:\n%s", want, body)
+		}
+	}
+
+	if strings.Contains(body, "snmp_auth_selected") {
+		t.Errorf("Unexpected snmp_auth_selected for a single auth:\n%s", body)
+	}
+	for params, want := range map[string]string{
+		"&auth=private_v2":           `snmp_auth_selected{auth="public_v2"} 1`,
+		"&auth=private_v2,public_v1": `snmp_auth_selected{auth="public_v1"} 1`,
+	} {
+		code, body = scrape(params)
+		if code != http.StatusOK {
+			t.Fatalf("Unexpected status %d for %s: %s", code, params, body)
+		}
+		for _, want := range []string{want, `sysUpTime 123456`} {
+			if !strings.Contains(body, want) {
+				t.Errorf("Expected %q in scrape output for %s:\n%s", want, params, body)
+			}
+		}
+	}
+	if code, _ := scrape("&auth=private_v2,unknown"); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown auth, got %d", code)
+	}
+
+	agent.Drop(-1)
+	code, body = scrape()
+	if code != http.StatusInternalServerError || !strings.Contains(body, "snmp_error") || !strings.Contains(body, "timeout: ") {
+		t.Errorf("Expected the scrape of an unresponsive agent to fail with the timeout, got status %d:\n%s", code, body)
+	}
+
+	// The timeout makes the next scrape probe the auths again, and that none
+	// works is remembered, so the one after only scrapes with the first.
+	scrape("&auth=private_v2")
+	for i, want := range []uint64{6, 2} {
+		before := agent.Requests()
+		if code, _ := scrape("&auth=private_v2"); code != http.StatusInternalServerError {
+			t.Errorf("Expected the scrape of an unresponsive agent to fail, got status %d", code)
+		}
+		if got := uint64(agent.Requests() - before); got != want {
+			t.Errorf("Expected %d requests in scrape %d, got %d", want, i, got)
+		}
+	}
+}
+
+func TestAuthTestHandler(t *testing.T) {
+	agent, err := snmpsim.Load("testdata/device.snmprec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := agent.AddUser(snmpsim.User{Name: "user", AuthProtocol: gosnmp.SHA, AuthPassword: "authpassword", PrivProtocol: gosnmp.AES, PrivPassword: "privpassword"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := agent.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer agent.Close()
+
+	if err := sc.ReloadConfig([]string{"testdata/snmp-sim.yml"}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { sc.C = &config.Config{} }()
+
+	metrics := testExporterMetrics()
+	r := httptest.NewRequest("GET", "/snmp/auth-test?module=sim&auth=public_v2,private_v2&auth=user_v3&target="+agent.Addr(), nil)
+	w := httptest.NewRecorder()
+	authTestHandler(w, r, log.NewNopLogger(), metrics)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d: %s", w.Code, w.Body.String())
+	}
+	var report struct {
+		Target  string                      `json:"target"`
+		Results []collector.AuthCheckResult `json:"results"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Results) != 3 {
+		t.Fatalf("Expected 3 results, got %+v", report.Results)
+	}
+	public, private, v3 := report.Results[0], report.Results[1], report.Results[2]
+	if public.Auth != "public_v2" || !public.Success || public.SysObjectID != "1.3.6.1.4.1.8072.3.2.10" {
+		t.Errorf("Unexpected result for public_v2: %+v", public)
+	}
+	if private.Auth != "private_v2" || private.Success || private.Reason != "timeout" {
+		t.Errorf("Unexpected result for private_v2: %+v", private)
+	}
+	if v3.Auth != "user_v3" || !v3.Success || v3.EngineID != hex.EncodeToString([]byte("\x80\x00\x1f\x88\x80snmpsim")) {
+		t.Errorf("Unexpected result for user_v3: %+v", v3)
+	}
+	// The probes are accounted like the packets of scrapes.
+	if got := testutil.ToFloat64(metrics.SNMPPackets); got != float64(agent.Requests()) {
+		t.Errorf("Expected %d packets, got %v", agent.Requests(), got)
+	}
+
+	r = httptest.NewRequest("GET", "/snmp/auth-test?auth=unknown&target="+agent.Addr(), nil)
+	w = httptest.NewRecorder()
+	authTestHandler(w, r, log.NewNopLogger(), testExporterMetrics())
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown auth, got %d", w.Code)
+	}
+
+	// Auth tests take a slot of the scrape limiter.
+	limiter = newScrapeLimiter(1, 0)
+	defer func() { limiter = nil }()
+	if err := limiter.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	requests := agent.Requests()
+	r = httptest.NewRequest("GET", "/snmp/auth-test?auth=public_v2&target="+agent.Addr(), nil)
+	w = httptest.NewRecorder()
+	authTestHandler(w, r, log.NewNopLogger(), testExporterMetrics())
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 with no free slot, got %d", w.Code)
+	}
+	if agent.Requests() != requests {
+		t.Errorf("Expected no requests to the target with no free slot")
+	}
+}
+
+func TestHandlerInventoryTarget(t *testing.T) {
+	agent, err := snmpsim.Load("testdata/device.snmprec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := agent.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer agent.Close()
+
+	inventory := filepath.Join(t.TempDir(), "targets.yml")
+	content := fmt.Sprintf(`targets:
+  core-sw-1:
+    address: %s
+    auth: private_v2
+    modules: [sim]
+    walk_params:
+      timeout: 100ms
+    labels:
+      site: lab
+`, agent.Addr())
+	if err := os.WriteFile(inventory, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := sc.ReloadConfig([]string{"testdata/snmp-sim.yml", inventory}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { sc.C = &config.Config{} }()
+
+	r := httptest.NewRequest("GET", "/snmp?target=core-sw-1", nil)
+	w := httptest.NewRecorder()
+	handler(w, r, log.NewNopLogger(), testExporterMetrics())
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d: %s", w.Code, w.Body.String())
+	}
+	for _, want := range []string{
+		`sysUpTime{site="lab"} 123456`,
+		`snmp_auth_selected{auth="public_v2",site="lab"} 1`,
+		`snmp_scrape_pdus_returned{module="sim",site="lab"} 5`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("Expected %q in scrape output:\n%s", want, w.Body.String())
+		}
+	}
+
+	if err := os.WriteFile(inventory, []byte("targets:\n  core-sw-1:\n    address: 127.0.0.1\n    modules: [unknown]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := sc.ReloadConfig([]string{"testdata/snmp-sim.yml", inventory}); err == nil {
+		t.Error("Expected an error loading a target with an unknown module")
+	}
+}
+
+func TestSDHandler(t *testing.T) {
+	inventory := filepath.Join(t.TempDir(), "targets.yml")
+	content := `targets:
+  core-sw-2:
+    address: 192.0.0.9
+    auth: public_v2
+    modules: [sim]
+  core-sw-1:
+    address: 192.0.0.8
+    auth: public_v2
+    modules: [sim]
+  access-sw-1:
+    address: 192.0.0.10
+    auth: private_v2
+    modules: [sim]
+  default-1:
+    address: 192.0.0.11
+`
+	if err := os.WriteFile(inventory, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := sc.ReloadConfig([]string{"testdata/snmp-sim.yml", inventory}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { sc.C = &config.Config{} }()
+
+	r := httptest.NewRequest("GET", "http://exporter:9116/sd", nil)
+	w := httptest.NewRecorder()
+	sdHandler(w, r, log.NewNopLogger())
+	var groups []sdTargetGroup
+	if err := json.Unmarshal(w.Body.Bytes(), &groups); err != nil {
+		t.Fatal(err)
+	}
+	want := []sdTargetGroup{
+		{Targets: []string{"exporter:9116"}, Labels: map[string]string{"__param_target": "default-1", "instance": "default-1"}},
+		{Targets: []string{"exporter:9116"}, Labels: map[string]string{"__param_target": "access-sw-1", "instance": "access-sw-1", "__param_module": "sim", "__param_auth": "private_v2"}},
+		{Targets: []string{"exporter:9116"}, Labels: map[string]string{"__param_target": "core-sw-1", "instance": "core-sw-1", "__param_module": "sim", "__param_auth": "public_v2"}},
+		{Targets: []string{"exporter:9116"}, Labels: map[string]string{"__param_target": "core-sw-2", "instance": "core-sw-2", "__param_module": "sim", "__param_auth": "public_v2"}},
+	}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("Unexpected target groups:\nwant %v\ngot  %v", want, groups)
+	}
+}
+
+func TestReloadConfigSecrets(t *testing.T) {
+	dir := t.TempDir()
+	communityFile := filepath.Join(dir, "community")
+	if err := os.WriteFile(communityFile, []byte("secret-community\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SNMP_TEST_PASSWORD", "secret-password")
+	configFile := filepath.Join(dir, "snmp.yml")
+	content := fmt.Sprintf(`auths:
+  from_file:
+    community_file: %s
+  from_env:
+    version: 3
+    username: user
+    security_level: authNoPriv
+    auth_protocol: SHA
+    password: ${SNMP_TEST_PASSWORD}
+`, communityFile)
+	if err := os.WriteFile(configFile, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := sc.ReloadConfig([]string{configFile}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { sc.C = &config.Config{} }()
+
+	if got := sc.C.Auths["from_file"].Community; got != "secret-community" {
+		t.Errorf("Expected community from file, got %q", got)
+	}
+	if got := sc.C.Auths["from_env"].Password; got != "secret-password" {
+		t.Errorf("Expected password from environment, got %q", got)
+	}
+	out, err := yaml.Marshal(sc.C)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "secret-") {
+		t.Errorf("Secrets revealed in marshaled config:\n%s", out)
+	}
+
+	// Changed files are read again on reload.
+	if err := os.WriteFile(communityFile, []byte("rotated"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := sc.ReloadConfig([]string{configFile}); err != nil {
+		t.Fatal(err)
+	}
+	if got := sc.C.Auths["from_file"].Community; got != "rotated" {
+		t.Errorf("Expected rotated community after reload, got %q", got)
+	}
+
+	os.Unsetenv("SNMP_TEST_PASSWORD")
+	if err := sc.ReloadConfig([]string{configFile}); err == nil {
+		t.Error("Expected an error for an unset environment variable")
+	}
+}
+
+func TestReloadConfigMetrics(t *testing.T) {
+	sc := &SafeConfig{}
+	if err := sc.ReloadConfig([]string{"testdata/snmp-sim.yml"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(snmpConfigLastReloadSuccessful); got != 1 {
+		t.Errorf("Expected successful reload, got %v", got)
+	}
+	success := testutil.ToFloat64(snmpConfigLastReloadSuccessTimestamp)
+	if success == 0 {
+		t.Error("Expected reload success timestamp")
+	}
+	hash := sc.C.Hash
+	if got := testutil.ToFloat64(snmpConfigInfo.WithLabelValues(hash)); got != 1 {
+		t.Errorf("Expected config info for hash %s, got %v", hash, got)
+	}
+
+	if err := sc.ReloadConfig([]string{"testdata/snmp-invalid.yml"}); err == nil {
+		t.Fatal("Expected error loading invalid config")
+	}
+	if got := testutil.ToFloat64(snmpConfigLastReloadSuccessful); got != 0 {
+		t.Errorf("Expected failed reload, got %v", got)
+	}
+	if got := testutil.ToFloat64(snmpConfigLastReloadSuccessTimestamp); got != success {
+		t.Errorf("Expected unchanged reload success timestamp, got %v", got)
+	}
+	if got := testutil.CollectAndCount(snmpConfigInfo); got != 1 {
+		t.Errorf("Expected info of the config in use only, got %d series", got)
+	}
+
+	if err := sc.ReloadConfig([]string{"testdata/snmp-on-error.yml"}); err != nil {
+		t.Fatal(err)
+	}
+	if sc.C.Hash == hash {
+		t.Error("Expected a different hash for a different config")
+	}
+	if got := testutil.CollectAndCount(snmpConfigInfo); got != 1 {
+		t.Errorf("Expected info of the config in use only, got %d series", got)
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.yml", "modules: {}\n")
+	reloads := make(chan struct{}, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	paths := func() []string { return []string{filepath.Join(dir, "*.yml")} }
+	go watchConfig(ctx, paths, 10*time.Millisecond, 100*time.Millisecond, log.NewNopLogger(), func() {
+		reloads <- struct{}{}
+	})
+
+	select {
+	case <-reloads:
+		t.Fatal("Unexpected reload of unchanged files")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// Changes in quick succession are reloaded once.
+	write("a.yml", "modules: {}\nauths: {}\n")
+	time.Sleep(30 * time.Millisecond)
+	write("b.yml", "modules: {}\n")
+	select {
+	case <-reloads:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected reload of changed files")
+	}
+	select {
+	case <-reloads:
+		t.Fatal("Expected a single reload")
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestWatchConfigSecrets(t *testing.T) {
+	dir := t.TempDir()
+	community := filepath.Join(dir, "community")
+	configFile := filepath.Join(dir, "snmp.yml")
+	if err := os.WriteFile(community, []byte("old\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	content := fmt.Sprintf("auths:\n  rotated:\n    community_file: %s\n", community)
+	if err := os.WriteFile(configFile, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sc := &SafeConfig{}
+	if err := sc.ReloadConfig([]string{configFile}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	defer func() {
+		cancel()
+		<-done
+	}()
+	paths := func() []string {
+		sc.RLock()
+		defer sc.RUnlock()
+		return watchedPaths([]string{configFile}, "", sc.C)
+	}
+	go func() {
+		defer close(done)
+		watchConfig(ctx, paths, 10*time.Millisecond, 20*time.Millisecond, log.NewNopLogger(), func() {
+			sc.ReloadConfig([]string{configFile})
+		})
+	}()
+	// Let the watcher take the state of the files before they change.
+	time.Sleep(50 * time.Millisecond)
+
+	// A rotated secret is loaded without a change of the config file.
+	if err := os.WriteFile(community, []byte("new\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for start := time.Now(); time.Since(start) < 2*time.Second; time.Sleep(10 * time.Millisecond) {
+		sc.RLock()
+		got := sc.C.Auths["rotated"].Community
+		sc.RUnlock()
+		if got == "new" {
+			return
+		}
+	}
+	t.Error("Expected the rotated secret to be loaded")
+}
+
+func TestWatchedPaths(t *testing.T) {
+	if got := watchedPaths([]string{"snmp.yml"}, "", nil); !reflect.DeepEqual(got, []string{"snmp.yml"}) {
+		t.Errorf("Expected only the config file, got %v", got)
+	}
+	conf := &config.Config{Auths: map[string]*config.Auth{
+		"a": {CommunityFile: "community"},
+		"b": {PasswordFile: "password", PrivPasswordFile: "priv"},
+		"c": {CommunityFile: "community"},
+	}}
+	want := []string{"snmp.yml", "mappings.yml", "community", "password", "priv"}
+	if got := watchedPaths([]string{"snmp.yml"}, "mappings.yml", conf); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestMigrateConfig(t *testing.T) {
+	old := `modules:
+  if_mib:
+    walk:
+    - 1.3.6.1.2.1.2
+  sys:
+    version: 2
+    auth:
+      community: public
+    get:
+    - 1.3.6.1.2.1.1.3.0
+  secure:
+    version: 3
+    timeout: 10s
+    auth:
+      username: admin
+      security_level: authNoPriv
+      password: secret
+  private:
+    version: 1
+    auth:
+      community: s3cret
+`
+	out, order, mapping, err := migrateConfig([]byte(old))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"if_mib", "sys", "secure", "private"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("Expected modules %v, got %v", want, order)
+	}
+	want := map[string]moduleAuth{
+		"if_mib":  {Module: "if_mib", Auth: "public_v2"},
+		"sys":     {Module: "sys", Auth: "public_v2"},
+		"secure":  {Module: "secure", Auth: "admin_v3"},
+		"private": {Module: "private", Auth: "private_v1"},
+	}
+	if !reflect.DeepEqual(mapping, want) {
+		t.Errorf("Expected mapping %v, got %v", want, mapping)
+	}
+
+	cfg := &config.Config{}
+	if err := yaml.UnmarshalStrict(out, cfg); err != nil {
+		t.Fatalf("Error parsing migrated config: %v\n%s", err, out)
+	}
+	if len(cfg.Auths) != 3 {
+		t.Errorf("Expected 3 auths, got %d", len(cfg.Auths))
+	}
+	if got := cfg.Auths["admin_v3"].Password; got != "secret" {
+		t.Errorf("Expected password of admin_v3, got %q", got)
+	}
+	if got := cfg.Modules["secure"].WalkParams.Timeout; got != 10*time.Second {
+		t.Errorf("Expected module timeout to be kept, got %s", got)
+	}
+
+	if _, _, _, err := migrateConfig(out); err == nil {
+		t.Error("Expected error migrating a config in the current format")
+	}
+}
+
+func TestMigrateConfigFlat(t *testing.T) {
+	content, err := os.ReadFile("testdata/snmp-v0.22.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, order, mapping, err := migrateConfig(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"if_mib", "synology", "ddwrt"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("Expected modules %v, got %v", want, order)
+	}
+	want := map[string]moduleAuth{
+		"if_mib":   {Module: "if_mib", Auth: "public_v2"},
+		"synology": {Module: "synology", Auth: "monitor_v3"},
+		"ddwrt":    {Module: "ddwrt", Auth: "public_v2"},
+	}
+	if !reflect.DeepEqual(mapping, want) {
+		t.Errorf("Expected mapping %v, got %v", want, mapping)
+	}
+
+	// The migrated file is loaded by the exporter.
+	output := filepath.Join(t.TempDir(), "snmp.yml")
+	if err := runMigrateConfig("testdata/snmp-v0.22.yml", output); err != nil {
+		t.Fatal(err)
+	}
+	sc := &SafeConfig{}
+	if err := sc.ReloadConfig([]string{output}); err != nil {
+		t.Fatalf("Error loading migrated config: %v", err)
+	}
+	if got := len(sc.C.Modules["if_mib"].Metrics); got != 2 {
+		t.Errorf("Expected 2 metrics in if_mib, got %d", got)
+	}
+	if got := sc.C.Auths["monitor_v3"].PrivPassword; got != "secret2" {
+		t.Errorf("Expected priv password of monitor_v3, got %q", got)
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/shatteredsilicon/snmp_exporter/config"
+)
+
+// moduleAuth is the module and auth an old module is scraped with after the
+// migration.
+type moduleAuth struct {
+	Module string
+	Auth   string
+}
+
+// migratedAuth is an auth split off from old modules.
+type migratedAuth struct {
+	name string
+	// The settings as written in the old modules, and with the defaults
+	// applied to compare them.
+	settings yaml.MapSlice
+	auth     config.Auth
+}
+
+// migrateConfig converts an snmp.yml or generator.yml from before v0.23.0,
+// with the version and auth settings in each module, to separate auths and
+// modules. The modules of an snmp.yml are at the top level, those of a
+// generator.yml under modules. Identical auths of several modules become one. It returns the
+// converted file and the module and auth each old module maps to, in the
+// order of the modules.
+func migrateConfig(content []byte) ([]byte, []string, map[string]moduleAuth, error) {
+	var old yaml.MapSlice
+	if err := yaml.Unmarshal(content, &old); err != nil {
+		return nil, nil, nil, err
+	}
+	var modules yaml.MapSlice
+	rest := yaml.MapSlice{}
+	nested := false
+	for _, item := range old {
+		switch item.Key {
+		case "auths":
+			return nil, nil, nil, fmt.Errorf("the file already has auths, it is in the current format")
+		case "modules":
+			m, ok := item.Value.(yaml.MapSlice)
+			if !ok {
+				return nil, nil, nil, fmt.Errorf("modules must be a map")
+			}
+			modules, nested = m, true
+		default:
+			rest = append(rest, item)
+		}
+	}
+	if !nested {
+		// An snmp.yml has the modules at the top level, without the modules
+		// key generator.yml has.
+		modules, rest = rest, yaml.MapSlice{}
+	}
+	if len(modules) == 0 {
+		return nil, nil, nil, fmt.Errorf("no modules found")
+	}
+
+	var auths []*migratedAuth
+	names := map[string]bool{}
+	order := make([]string, 0, len(modules))
+	mapping := make(map[string]moduleAuth, len(modules))
+	newModules := make(yaml.MapSlice, 0, len(modules))
+	for _, item := range modules {
+		name := fmt.Sprint(item.Key)
+		module, ok := item.Value.(yaml.MapSlice)
+		if !ok && item.Value != nil {
+			return nil, nil, nil, fmt.Errorf("module %q must be a map", name)
+		}
+		settings := yaml.MapSlice{}
+		newModule := yaml.MapSlice{}
+		version := 2
+		for _, field := range module {
+			switch field.Key {
+			case "version":
+				v, ok := field.Value.(int)
+				if !ok {
+					return nil, nil, nil, fmt.Errorf("module %q: version must be a number", name)
+				}
+				version = v
+			case "auth":
+				s, ok := field.Value.(yaml.MapSlice)
+				if !ok && field.Value != nil {
+					return nil, nil, nil, fmt.Errorf("module %q: auth must be a map", name)
+				}
+				settings = s
+			default:
+				newModule = append(newModule, field)
+			}
+		}
+		settings = append(settings, yaml.MapItem{Key: "version", Value: version})
+
+		// Apply the defaults and check the auth like the exporter does.
+		out, err := yaml.Marshal(settings)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		var auth config.Auth
+		if err := yaml.UnmarshalStrict(out, &auth); err != nil {
+			return nil, nil, nil, fmt.Errorf("module %q: invalid auth: %w", name, err)
+		}
+
+		var match *migratedAuth
+		for _, a := range auths {
+			if reflect.DeepEqual(a.auth, auth) {
+				match = a
+				break
+			}
+		}
+		if match == nil {
+			match = &migratedAuth{
+				name:     uniqueName(authName(name, auth), names),
+				settings: settings,
+				auth:     auth,
+			}
+			auths = append(auths, match)
+		}
+		order = append(order, name)
+		mapping[name] = moduleAuth{Module: name, Auth: match.name}
+		newModules = append(newModules, yaml.MapItem{Key: item.Key, Value: newModule})
+	}
+
+	newAuths := make(yaml.MapSlice, 0, len(auths))
+	for _, a := range auths {
+		newAuths = append(newAuths, yaml.MapItem{Key: a.name, Value: a.settings})
+	}
+	migrated := append(yaml.MapSlice{
+		{Key: "auths", Value: newAuths},
+		{Key: "modules", Value: newModules},
+	}, rest...)
+	out, err := yaml.Marshal(migrated)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return out, order, mapping, nil
+}
+
+// authName names an auth after its community if it's the well known public
+// one, after its username for SNMPv3, and otherwise after the first module
+// using it, so that no secrets end up in the name.
+func authName(module string, auth config.Auth) string {
+	switch {
+	case auth.Version == 3:
+		return fmt.Sprintf("%s_v3", auth.Username)
+	case auth.Community == "public":
+		return fmt.Sprintf("public_v%d", auth.Version)
+	default:
+		return fmt.Sprintf("%s_v%d", module, auth.Version)
+	}
+}
+
+func uniqueName(name string, names map[string]bool) string {
+	unique := name
+	for i := 2; names[unique]; i++ {
+		unique = fmt.Sprintf("%s_%d", name, i)
+	}
+	names[unique] = true
+	return unique
+}
+
+// runMigrateConfig migrates the input file, writes the result to output, or
+// standard output for "-", and prints which module and auth replace each old
+// module.
+func runMigrateConfig(input, output string) error {
+	content, err := os.ReadFile(input)
+	if err != nil {
+		return err
+	}
+	out, order, mapping, err := migrateConfig(content)
+	if err != nil {
+		return fmt.Errorf("error migrating %s: %w", input, err)
+	}
+	var report io.Writer = os.Stdout
+	if output == "-" {
+		if _, err := os.Stdout.Write(out); err != nil {
+			return err
+		}
+		report = os.Stderr
+	} else if err := os.WriteFile(output, out, 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintln(report, "Old module -> scrape parameters:")
+	for _, name := range order {
+		fmt.Fprintf(report, "%s -> module=%s auth=%s\n", name, mapping[name].Module, mapping[name].Auth)
+	}
+	return nil
+}
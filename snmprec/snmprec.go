@@ -0,0 +1,193 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package snmprec reads and writes SNMP PDUs in the snmprec format, one
+// "oid|tag|value" line per OID, where tag is the BER type of the value.
+package snmprec
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// FormatLine renders a PDU as an snmprec line. Octet strings are always hex
+// encoded, so that they round trip unchanged.
+func FormatLine(pdu gosnmp.SnmpPDU) (string, error) {
+	oid := strings.TrimPrefix(pdu.Name, ".")
+	var tag, value string
+	switch pdu.Type {
+	case gosnmp.OctetString, gosnmp.Opaque, gosnmp.BitString:
+		b, ok := pdu.Value.([]byte)
+		if !ok {
+			return "", fmt.Errorf("unexpected value %T for %s at %s", pdu.Value, pdu.Type, oid)
+		}
+		tag, value = fmt.Sprintf("%dx", pdu.Type), hex.EncodeToString(b)
+	case gosnmp.ObjectIdentifier:
+		tag, value = strconv.Itoa(int(pdu.Type)), strings.TrimPrefix(fmt.Sprint(pdu.Value), ".")
+	case gosnmp.Null, gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView:
+		tag = strconv.Itoa(int(pdu.Type))
+	case gosnmp.IPAddress:
+		tag = strconv.Itoa(int(pdu.Type))
+		if pdu.Value != nil {
+			value = fmt.Sprint(pdu.Value)
+		}
+	case gosnmp.OpaqueFloat:
+		tag, value = strconv.Itoa(int(pdu.Type)), strconv.FormatFloat(float64(pdu.Value.(float32)), 'g', -1, 32)
+	case gosnmp.OpaqueDouble:
+		tag, value = strconv.Itoa(int(pdu.Type)), strconv.FormatFloat(pdu.Value.(float64), 'g', -1, 64)
+	case gosnmp.Integer, gosnmp.Counter32, gosnmp.Gauge32, gosnmp.TimeTicks, gosnmp.Counter64, gosnmp.Uinteger32:
+		tag, value = strconv.Itoa(int(pdu.Type)), gosnmp.ToBigInt(pdu.Value).String()
+	default:
+		return "", fmt.Errorf("unsupported type %s at %s", pdu.Type, oid)
+	}
+	return oid + "|" + tag + "|" + value, nil
+}
+
+// ParseLine parses an snmprec line into a PDU with the same Go value types
+// as gosnmp decodes from the wire.
+func ParseLine(line string) (gosnmp.SnmpPDU, error) {
+	parts := strings.SplitN(line, "|", 3)
+	if len(parts) != 3 {
+		return gosnmp.SnmpPDU{}, fmt.Errorf("invalid snmprec line %q", line)
+	}
+	oid, tag, value := parts[0], parts[1], parts[2]
+	hexValue := strings.HasSuffix(tag, "x")
+	t, err := strconv.Atoi(strings.TrimSuffix(tag, "x"))
+	if err != nil {
+		return gosnmp.SnmpPDU{}, fmt.Errorf("invalid type in snmprec line %q: %w", line, err)
+	}
+	pdu := gosnmp.SnmpPDU{Name: "." + oid, Type: gosnmp.Asn1BER(t)}
+	switch pdu.Type {
+	case gosnmp.OctetString, gosnmp.Opaque, gosnmp.BitString:
+		if hexValue {
+			pdu.Value, err = hex.DecodeString(value)
+		} else {
+			pdu.Value = []byte(value)
+		}
+	case gosnmp.ObjectIdentifier:
+		pdu.Value = "." + strings.TrimPrefix(value, ".")
+	case gosnmp.Null, gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView:
+	case gosnmp.IPAddress:
+		if value != "" {
+			pdu.Value = value
+		}
+	case gosnmp.Integer:
+		pdu.Value, err = strconv.Atoi(value)
+	case gosnmp.Counter32, gosnmp.Gauge32:
+		var v uint64
+		v, err = strconv.ParseUint(value, 10, 32)
+		pdu.Value = uint(v)
+	case gosnmp.TimeTicks, gosnmp.Uinteger32:
+		var v uint64
+		v, err = strconv.ParseUint(value, 10, 32)
+		pdu.Value = uint32(v)
+	case gosnmp.Counter64:
+		pdu.Value, err = strconv.ParseUint(value, 10, 64)
+	case gosnmp.OpaqueFloat:
+		var v float64
+		v, err = strconv.ParseFloat(value, 32)
+		pdu.Value = float32(v)
+	case gosnmp.OpaqueDouble:
+		pdu.Value, err = strconv.ParseFloat(value, 64)
+	default:
+		return gosnmp.SnmpPDU{}, fmt.Errorf("unsupported type %d in snmprec line %q", t, line)
+	}
+	if err != nil {
+		return gosnmp.SnmpPDU{}, fmt.Errorf("invalid value in snmprec line %q: %w", line, err)
+	}
+	return pdu, nil
+}
+
+func oidToList(oid string) []int {
+	result := []int{}
+	for _, x := range strings.Split(strings.TrimPrefix(oid, "."), ".") {
+		o, _ := strconv.Atoi(x)
+		result = append(result, o)
+	}
+	return result
+}
+
+// CompareOids compares two numeric OIDs, with or without a leading dot, in
+// lexicographic OID order. The result is negative, 0 or positive if a is
+// before, equal to or after b.
+func CompareOids(a, b string) int {
+	x, y := oidToList(a), oidToList(b)
+	for i := 0; i < len(x) && i < len(y); i++ {
+		if x[i] != y[i] {
+			return x[i] - y[i]
+		}
+	}
+	return len(x) - len(y)
+}
+
+// Sort sorts PDUs in OID order.
+func Sort(pdus []gosnmp.SnmpPDU) {
+	sort.Slice(pdus, func(i, j int) bool {
+		return CompareOids(pdus[i].Name, pdus[j].Name) < 0
+	})
+}
+
+// ReadFile reads the PDUs of an snmprec file, sorted in OID order. Empty
+// lines and lines starting with # are skipped.
+func ReadFile(path string) ([]gosnmp.SnmpPDU, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	pdus := []gosnmp.SnmpPDU{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pdu, err := ParseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", path, err)
+		}
+		pdus = append(pdus, pdu)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	Sort(pdus)
+	return pdus, nil
+}
+
+// WriteFile atomically replaces the snmprec file with the PDUs.
+func WriteFile(path string, pdus []gosnmp.SnmpPDU) error {
+	var b strings.Builder
+	for _, pdu := range pdus {
+		line, err := FormatLine(pdu)
+		if err != nil {
+			return err
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,75 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snmprec
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+func TestSnmprecRoundTrip(t *testing.T) {
+	pdus := []gosnmp.SnmpPDU{
+		{Name: ".1.3.6.1.2.1.1.1.0", Type: gosnmp.OctetString, Value: []byte("Linux host\x00\xff")},
+		{Name: ".1.3.6.1.2.1.1.2.0", Type: gosnmp.ObjectIdentifier, Value: ".1.3.6.1.4.1.8072.3.2.10"},
+		{Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.TimeTicks, Value: uint32(12345)},
+		{Name: ".1.3.6.1.2.1.2.2.1.3.1", Type: gosnmp.Integer, Value: -6},
+		{Name: ".1.3.6.1.2.1.2.2.1.10.1", Type: gosnmp.Counter32, Value: uint(4294967295)},
+		{Name: ".1.3.6.1.2.1.2.2.1.5.1", Type: gosnmp.Gauge32, Value: uint(1000000000)},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.6.1", Type: gosnmp.Counter64, Value: uint64(18446744073709551615)},
+		{Name: ".1.3.6.1.2.1.4.20.1.1.10.0.0.1", Type: gosnmp.IPAddress, Value: "10.0.0.1"},
+		{Name: ".1.3.6.1.4.1.2021.10.1.6.1", Type: gosnmp.Opaque, Value: []byte{0x9f, 0x78, 0x04}},
+		{Name: ".1.3.6.1.4.1.2021.10.1.6.2", Type: gosnmp.OpaqueFloat, Value: float32(0.25)},
+		{Name: ".1.3.6.1.4.1.2021.10.1.6.3", Type: gosnmp.OpaqueDouble, Value: float64(1.5)},
+		{Name: ".1.3.6.1.4.1.2021.10.1.6.4", Type: gosnmp.Null, Value: nil},
+	}
+	for _, pdu := range pdus {
+		line, err := FormatLine(pdu)
+		if err != nil {
+			t.Fatalf("Error formatting %v: %s", pdu, err)
+		}
+		got, err := ParseLine(line)
+		if err != nil {
+			t.Fatalf("Error parsing %q: %s", line, err)
+		}
+		if !reflect.DeepEqual(got, pdu) {
+			t.Errorf("Round trip of %q: want %#v, got %#v", line, pdu, got)
+		}
+	}
+
+	for _, line := range []string{"1.3.6.1|4", "1.3.6.1|abc|1", "1.3.6.1|2|abc", "1.3.6.1|4x|zz", "1.3.6.1|99|1"} {
+		if _, err := ParseLine(line); err == nil {
+			t.Errorf("Expected error parsing %q", line)
+		}
+	}
+}
+
+func TestCompareOids(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{a: "1.3.6.1.2", b: ".1.3.6.1.2", want: 0},
+		{a: "1.3.6.1.2", b: "1.3.6.1.10", want: -1},
+		{a: "1.3.6.1.10.1", b: "1.3.6.1.2.5", want: 1},
+		{a: "1.3.6.1", b: "1.3.6.1.0", want: -1},
+	}
+	for _, c := range cases {
+		got := CompareOids(c.a, c.b)
+		if (got < 0 && c.want >= 0) || (got > 0 && c.want <= 0) || (got == 0 && c.want != 0) {
+			t.Errorf("CompareOids(%s, %s): want sign %d, got %d", c.a, c.b, c.want, got)
+		}
+	}
+}
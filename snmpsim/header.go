@@ -0,0 +1,116 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snmpsim
+
+import (
+	"errors"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// header holds the parts of a message needed to pick the v3 user before
+// the message can be authenticated and decrypted.
+type header struct {
+	version  gosnmp.SnmpVersion
+	msgID    uint32
+	flags    gosnmp.SnmpV3MsgFlags
+	engineID string
+	userName string
+}
+
+var errMalformed = errors.New("malformed message")
+
+// berElement splits the first BER element off b.
+func berElement(b []byte) (tag byte, value, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errMalformed
+	}
+	tag = b[0]
+	length, offset := int(b[1]), 2
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 || len(b) < 2+n {
+			return 0, nil, nil, errMalformed
+		}
+		length = 0
+		for _, c := range b[2 : 2+n] {
+			length = length<<8 | int(c)
+		}
+		offset += n
+	}
+	if length < 0 || len(b) < offset+length {
+		return 0, nil, nil, errMalformed
+	}
+	return tag, b[offset : offset+length], b[offset+length:], nil
+}
+
+func berInt(b []byte) int {
+	v := 0
+	for _, c := range b {
+		v = v<<8 | int(c)
+	}
+	return v
+}
+
+// parseHeader parses the version and, for v3, the message and USM headers
+// of a message.
+func parseHeader(msg []byte) (header, error) {
+	var h header
+	_, body, _, err := berElement(msg)
+	if err != nil {
+		return h, err
+	}
+	_, version, body, err := berElement(body)
+	if err != nil {
+		return h, err
+	}
+	h.version = gosnmp.SnmpVersion(berInt(version))
+	if h.version != gosnmp.Version3 {
+		return h, nil
+	}
+
+	_, global, body, err := berElement(body)
+	if err != nil {
+		return h, err
+	}
+	var fields [4][]byte
+	for i := range fields {
+		if _, fields[i], global, err = berElement(global); err != nil {
+			return h, err
+		}
+	}
+	h.msgID = uint32(berInt(fields[0]))
+	if len(fields[2]) != 1 {
+		return h, errMalformed
+	}
+	h.flags = gosnmp.SnmpV3MsgFlags(fields[2][0])
+
+	_, security, _, err := berElement(body)
+	if err != nil {
+		return h, err
+	}
+	_, usm, _, err := berElement(security)
+	if err != nil {
+		return h, err
+	}
+	var usmFields [4][]byte
+	for i := range usmFields {
+		if _, usmFields[i], usm, err = berElement(usm); err != nil {
+			return h, err
+		}
+	}
+	h.engineID = string(usmFields[0])
+	h.userName = string(usmFields[3])
+	return h, nil
+}
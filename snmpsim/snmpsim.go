@@ -0,0 +1,502 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package snmpsim implements a simulated SNMP agent for tests. It serves an
+// OID tree over UDP to SNMP v1, v2c and v3 (USM) clients, and can be told to
+// delay, drop or fail requests.
+package snmpsim
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+
+	"github.com/shatteredsilicon/snmp_exporter/snmprec"
+)
+
+// USM statistics reported to v3 clients whose request was rejected.
+const (
+	usmStatsUnsupportedSecLevels = ".1.3.6.1.6.3.15.1.1.1.0"
+	usmStatsUnknownUserNames     = ".1.3.6.1.6.3.15.1.1.3.0"
+	usmStatsUnknownEngineIDs     = ".1.3.6.1.6.3.15.1.1.4.0"
+	usmStatsWrongDigests         = ".1.3.6.1.6.3.15.1.1.5.0"
+	usmStatsDecryptionErrors     = ".1.3.6.1.6.3.15.1.1.6.0"
+)
+
+// User is an SNMPv3 USM user of the agent.
+type User struct {
+	Name         string
+	AuthProtocol gosnmp.SnmpV3AuthProtocol
+	AuthPassword string
+	PrivProtocol gosnmp.SnmpV3PrivProtocol
+	PrivPassword string
+}
+
+// Agent is a simulated SNMP agent.
+type Agent struct {
+	mu          sync.Mutex
+	conn        *net.UDPConn
+	done        chan struct{}
+	pdus        []gosnmp.SnmpPDU
+	communities map[string]bool
+	users       map[string]*gosnmp.UsmSecurityParameters
+	engineID    string
+	started     time.Time
+	latency     time.Duration
+	drop        int
+	maxVarbinds int
+	errors      map[string]gosnmp.SNMPError
+	requests    int
+}
+
+// New returns an agent serving the PDUs, answering to the "public"
+// community and no v3 users.
+func New(pdus []gosnmp.SnmpPDU) *Agent {
+	tree := make([]gosnmp.SnmpPDU, len(pdus))
+	copy(tree, pdus)
+	snmprec.Sort(tree)
+	return &Agent{
+		pdus:        tree,
+		communities: map[string]bool{"public": true},
+		users:       map[string]*gosnmp.UsmSecurityParameters{},
+		engineID:    "\x80\x00\x1f\x88\x80snmpsim",
+		errors:      map[string]gosnmp.SNMPError{},
+	}
+}
+
+// Load returns an agent serving the PDUs of an snmprec file.
+func Load(path string) (*Agent, error) {
+	pdus, err := snmprec.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return New(pdus), nil
+}
+
+// SetCommunities replaces the communities v1 and v2c requests are answered for.
+func (a *Agent) SetCommunities(communities ...string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.communities = map[string]bool{}
+	for _, c := range communities {
+		a.communities[c] = true
+	}
+}
+
+// AddUser adds a v3 user. Its keys are localized to the engine ID of the agent.
+func (a *Agent) AddUser(user User) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	sp := &gosnmp.UsmSecurityParameters{
+		UserName:                 user.Name,
+		AuthenticationProtocol:   user.AuthProtocol,
+		AuthenticationPassphrase: user.AuthPassword,
+		PrivacyProtocol:          user.PrivProtocol,
+		PrivacyPassphrase:        user.PrivPassword,
+		AuthoritativeEngineID:    a.engineID,
+	}
+	if sp.AuthenticationProtocol == 0 {
+		sp.AuthenticationProtocol = gosnmp.NoAuth
+	}
+	if sp.PrivacyProtocol == 0 {
+		sp.PrivacyProtocol = gosnmp.NoPriv
+	}
+	if err := sp.InitSecurityKeys(); err != nil {
+		return fmt.Errorf("error initializing keys of user %s: %w", user.Name, err)
+	}
+	a.users[user.Name] = sp
+	return nil
+}
+
+// SetLatency delays every response by d.
+func (a *Agent) SetLatency(d time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.latency = d
+}
+
+// Drop silently drops the next n requests. A negative n drops all requests
+// until Drop is called again.
+func (a *Agent) Drop(n int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.drop = n
+}
+
+// SetMaxVarbinds answers requests with more than n varbinds in their
+// response with a tooBig error. 0 means no limit.
+func (a *Agent) SetMaxVarbinds(n int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.maxVarbinds = n
+}
+
+// SetError answers requests for the OID, or any OID under it, with the error
+// status. gosnmp.NoError removes the error.
+func (a *Agent) SetError(oid string, status gosnmp.SNMPError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	oid = "." + strings.TrimPrefix(oid, ".")
+	if status == gosnmp.NoError {
+		delete(a.errors, oid)
+		return
+	}
+	a.errors[oid] = status
+}
+
+// Requests returns the number of requests received, including dropped ones.
+func (a *Agent) Requests() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.requests
+}
+
+// Start listens on the UDP address, e.g. "127.0.0.1:0", and serves requests
+// until Close is called.
+func (a *Agent) Start(addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.conn = conn
+	a.done = make(chan struct{})
+	a.started = time.Now()
+	a.mu.Unlock()
+	go a.serve(conn, a.done)
+	return nil
+}
+
+// Addr returns the address the agent listens on.
+func (a *Agent) Addr() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.conn == nil {
+		return ""
+	}
+	return a.conn.LocalAddr().String()
+}
+
+// Close stops the agent.
+func (a *Agent) Close() error {
+	a.mu.Lock()
+	conn, done := a.conn, a.done
+	a.conn = nil
+	a.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+	err := conn.Close()
+	<-done
+	return err
+}
+
+func (a *Agent) serve(conn *net.UDPConn, done chan struct{}) {
+	defer close(done)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	buf := make([]byte, 65535)
+	for {
+		n, remote, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		msg := make([]byte, n)
+		copy(msg, buf[:n])
+
+		a.mu.Lock()
+		a.requests++
+		drop := a.drop != 0
+		if a.drop > 0 {
+			a.drop--
+		}
+		latency := a.latency
+		a.mu.Unlock()
+		if drop {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := a.handle(msg)
+			if err != nil || resp == nil {
+				return
+			}
+			if latency > 0 {
+				time.Sleep(latency)
+			}
+			conn.WriteToUDP(resp, remote)
+		}()
+	}
+}
+
+// handle returns the encoded response to a request, or nil if the request
+// is to be ignored.
+func (a *Agent) handle(msg []byte) ([]byte, error) {
+	hdr, err := parseHeader(msg)
+	if err != nil {
+		return nil, err
+	}
+	if hdr.version != gosnmp.Version3 {
+		return a.handleCommunity(msg, hdr.version)
+	}
+	return a.handleUSM(msg, hdr)
+}
+
+func (a *Agent) handleCommunity(msg []byte, version gosnmp.SnmpVersion) ([]byte, error) {
+	decoder := &gosnmp.GoSNMP{Version: version}
+	req, err := decoder.SnmpDecodePacket(msg)
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	ok := a.communities[req.Community]
+	a.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown community")
+	}
+	resp := a.respond(req)
+	resp.Version = version
+	resp.Community = req.Community
+	return resp.MarshalMsg()
+}
+
+func (a *Agent) handleUSM(msg []byte, hdr header) ([]byte, error) {
+	a.mu.Lock()
+	user, known := a.users[hdr.userName]
+	engineID, boots, engineTime := a.engineID, uint32(1), uint32(time.Since(a.started).Seconds())
+	a.mu.Unlock()
+
+	report := func(oid string) ([]byte, error) {
+		sp := &gosnmp.UsmSecurityParameters{
+			UserName:                 hdr.userName,
+			AuthoritativeEngineID:    engineID,
+			AuthoritativeEngineBoots: boots,
+			AuthoritativeEngineTime:  engineTime,
+		}
+		resp := &gosnmp.SnmpPacket{
+			Version:            gosnmp.Version3,
+			MsgFlags:           gosnmp.NoAuthNoPriv,
+			SecurityModel:      gosnmp.UserSecurityModel,
+			SecurityParameters: sp,
+			MsgID:              hdr.msgID,
+			ContextEngineID:    engineID,
+			PDUType:            gosnmp.Report,
+			Variables:          []gosnmp.SnmpPDU{{Name: oid, Type: gosnmp.Counter32, Value: uint32(1)}},
+		}
+		return resp.MarshalMsg()
+	}
+
+	if hdr.engineID != engineID {
+		// Engine discovery, or a client that's out of date.
+		return report(usmStatsUnknownEngineIDs)
+	}
+	if !known {
+		return report(usmStatsUnknownUserNames)
+	}
+	level := gosnmp.NoAuthNoPriv
+	if user.AuthenticationProtocol > gosnmp.NoAuth {
+		level = gosnmp.AuthNoPriv
+		if user.PrivacyProtocol > gosnmp.NoPriv {
+			level = gosnmp.AuthPriv
+		}
+	}
+	if hdr.flags&gosnmp.AuthPriv != level {
+		return report(usmStatsUnsupportedSecLevels)
+	}
+
+	decoder := &gosnmp.GoSNMP{
+		Version:            gosnmp.Version3,
+		SecurityModel:      gosnmp.UserSecurityModel,
+		MsgFlags:           level,
+		SecurityParameters: user.Copy(),
+	}
+	req, err := decoder.UnmarshalTrap(msg, true)
+	if err != nil {
+		if strings.Contains(err.Error(), "not authentic") {
+			return report(usmStatsWrongDigests)
+		}
+		if level == gosnmp.AuthPriv {
+			return report(usmStatsDecryptionErrors)
+		}
+		return nil, err
+	}
+
+	resp := a.respond(req)
+	sp := user.Copy().(*gosnmp.UsmSecurityParameters)
+	sp.AuthoritativeEngineBoots = boots
+	sp.AuthoritativeEngineTime = engineTime
+	resp.Version = gosnmp.Version3
+	resp.MsgFlags = level
+	resp.SecurityModel = gosnmp.UserSecurityModel
+	resp.SecurityParameters = sp
+	resp.MsgID = req.MsgID
+	resp.ContextEngineID = engineID
+	resp.ContextName = req.ContextName
+	if level == gosnmp.AuthPriv {
+		// Allocates a fresh salt from the user, for the response.
+		if err := user.InitPacket(resp); err != nil {
+			return nil, err
+		}
+	}
+	return resp.MarshalMsg()
+}
+
+// respond builds the response PDU to a get, get-next or get-bulk request.
+func (a *Agent) respond(req *gosnmp.SnmpPacket) *gosnmp.SnmpPacket {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	resp := &gosnmp.SnmpPacket{
+		PDUType:   gosnmp.GetResponse,
+		RequestID: req.RequestID,
+	}
+	v1 := req.Version == gosnmp.Version1
+
+	fail := func(status gosnmp.SNMPError, index int) *gosnmp.SnmpPacket {
+		resp.Error = status
+		resp.ErrorIndex = uint8(index)
+		resp.Variables = req.Variables
+		for i := range resp.Variables {
+			resp.Variables[i].Type = gosnmp.Null
+			resp.Variables[i].Value = nil
+		}
+		return resp
+	}
+
+	// requested maps each varbind of the response to the 1-based index of
+	// the request varbind it answers.
+	var requested []int
+	switch req.PDUType {
+	case gosnmp.GetRequest:
+		for i, v := range req.Variables {
+			pdu, ok := a.get(v.Name)
+			if !ok {
+				if v1 {
+					return fail(gosnmp.NoSuchName, i+1)
+				}
+				pdu = gosnmp.SnmpPDU{Name: v.Name, Type: gosnmp.NoSuchObject}
+			}
+			resp.Variables = append(resp.Variables, pdu)
+			requested = append(requested, i+1)
+		}
+	case gosnmp.GetNextRequest:
+		for i, v := range req.Variables {
+			pdu, ok := a.next(v.Name)
+			if !ok {
+				if v1 {
+					return fail(gosnmp.NoSuchName, i+1)
+				}
+				pdu = gosnmp.SnmpPDU{Name: v.Name, Type: gosnmp.EndOfMibView}
+			}
+			resp.Variables = append(resp.Variables, pdu)
+			requested = append(requested, i+1)
+		}
+	case gosnmp.GetBulkRequest:
+		nonRepeaters := int(req.NonRepeaters)
+		if nonRepeaters > len(req.Variables) {
+			nonRepeaters = len(req.Variables)
+		}
+		for i, v := range req.Variables[:nonRepeaters] {
+			pdu, ok := a.next(v.Name)
+			if !ok {
+				pdu = gosnmp.SnmpPDU{Name: v.Name, Type: gosnmp.EndOfMibView}
+			}
+			resp.Variables = append(resp.Variables, pdu)
+			requested = append(requested, i+1)
+		}
+		last := make([]string, 0, len(req.Variables)-nonRepeaters)
+		for _, v := range req.Variables[nonRepeaters:] {
+			last = append(last, v.Name)
+		}
+		for r := 0; r < int(req.MaxRepetitions) && len(last) > 0; r++ {
+			end := true
+			for i, name := range last {
+				pdu, ok := a.next(name)
+				if !ok {
+					pdu = gosnmp.SnmpPDU{Name: name, Type: gosnmp.EndOfMibView}
+				} else {
+					end = false
+				}
+				resp.Variables = append(resp.Variables, pdu)
+				requested = append(requested, nonRepeaters+i+1)
+				last[i] = pdu.Name
+			}
+			if end {
+				break
+			}
+		}
+	default:
+		return fail(gosnmp.GenErr, 0)
+	}
+
+	for i, pdu := range resp.Variables {
+		for oid, status := range a.errors {
+			if pdu.Name == oid || strings.HasPrefix(pdu.Name, oid+".") ||
+				req.Variables[requested[i]-1].Name == oid || strings.HasPrefix(req.Variables[requested[i]-1].Name, oid+".") {
+				return fail(status, requested[i])
+			}
+		}
+	}
+	if a.maxVarbinds > 0 && len(resp.Variables) > a.maxVarbinds {
+		return fail(gosnmp.TooBig, 0)
+	}
+	return resp
+}
+
+// get returns the PDU with the exact OID.
+func (a *Agent) get(oid string) (gosnmp.SnmpPDU, bool) {
+	i := a.search(oid)
+	if i < len(a.pdus) && snmprec.CompareOids(a.pdus[i].Name, oid) == 0 {
+		return a.pdus[i], true
+	}
+	return gosnmp.SnmpPDU{}, false
+}
+
+// next returns the first PDU after the OID.
+func (a *Agent) next(oid string) (gosnmp.SnmpPDU, bool) {
+	i := a.search(oid)
+	if i < len(a.pdus) && snmprec.CompareOids(a.pdus[i].Name, oid) == 0 {
+		i++
+	}
+	if i < len(a.pdus) {
+		return a.pdus[i], true
+	}
+	return gosnmp.SnmpPDU{}, false
+}
+
+// search returns the index of the first PDU not before the OID.
+func (a *Agent) search(oid string) int {
+	lo, hi := 0, len(a.pdus)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if snmprec.CompareOids(a.pdus[mid].Name, oid) < 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
@@ -0,0 +1,233 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snmpsim
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+func startAgent(t *testing.T) *Agent {
+	t.Helper()
+	a, err := Load("../testdata/device.snmprec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.Close() })
+	return a
+}
+
+func newClient(t *testing.T, a *Agent, version gosnmp.SnmpVersion) *gosnmp.GoSNMP {
+	t.Helper()
+	host, port, err := net.SplitHostPort(a.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := strconv.Atoi(port)
+	g := &gosnmp.GoSNMP{
+		Target:    host,
+		Port:      uint16(p),
+		Version:   version,
+		Community: "public",
+		Timeout:   200 * time.Millisecond,
+		Retries:   0,
+		MaxOids:   gosnmp.MaxOids,
+	}
+	return g
+}
+
+func connect(t *testing.T, g *gosnmp.GoSNMP) {
+	t.Helper()
+	if err := g.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { g.Conn.Close() })
+}
+
+func TestCommunityGetAndWalk(t *testing.T) {
+	a := startAgent(t)
+	for _, version := range []gosnmp.SnmpVersion{gosnmp.Version1, gosnmp.Version2c} {
+		g := newClient(t, a, version)
+		connect(t, g)
+
+		res, err := g.Get([]string{"1.3.6.1.2.1.1.5.0"})
+		if err != nil {
+			t.Fatalf("%s: %s", version, err)
+		}
+		if got := string(res.Variables[0].Value.([]byte)); got != "host" {
+			t.Errorf("%s: want sysName host, got %q", version, got)
+		}
+
+		var pdus []gosnmp.SnmpPDU
+		if version == gosnmp.Version1 {
+			pdus, err = g.WalkAll("1.3.6.1.2.1.2.2.1.2")
+		} else {
+			pdus, err = g.BulkWalkAll("1.3.6.1.2.1.2.2.1")
+		}
+		if err != nil {
+			t.Fatalf("%s: %s", version, err)
+		}
+		want := 10
+		if version == gosnmp.Version1 {
+			want = 2
+		}
+		if len(pdus) != want {
+			t.Errorf("%s: want %d PDUs, got %d: %v", version, want, len(pdus), pdus)
+		}
+	}
+
+	// Missing OIDs.
+	g := newClient(t, a, gosnmp.Version2c)
+	connect(t, g)
+	res, err := g.Get([]string{"1.3.6.1.2.1.1.4.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Variables[0].Type != gosnmp.NoSuchObject {
+		t.Errorf("want noSuchObject, got %s", res.Variables[0].Type)
+	}
+	g = newClient(t, a, gosnmp.Version1)
+	connect(t, g)
+	res, err = g.Get([]string{"1.3.6.1.2.1.1.5.0", "1.3.6.1.2.1.1.4.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Error != gosnmp.NoSuchName || res.ErrorIndex != 2 {
+		t.Errorf("want noSuchName at 2, got %s at %d", res.Error, res.ErrorIndex)
+	}
+
+	// Wrong community.
+	g = newClient(t, a, gosnmp.Version2c)
+	g.Community = "private"
+	connect(t, g)
+	if _, err := g.Get([]string{"1.3.6.1.2.1.1.5.0"}); err == nil {
+		t.Error("expected timeout for unknown community")
+	}
+}
+
+func TestUSM(t *testing.T) {
+	a := startAgent(t)
+	users := []User{
+		{Name: "noauth"},
+		{Name: "md5des", AuthProtocol: gosnmp.MD5, AuthPassword: "authpassword", PrivProtocol: gosnmp.DES, PrivPassword: "privpassword"},
+		{Name: "shaaes", AuthProtocol: gosnmp.SHA, AuthPassword: "authpassword", PrivProtocol: gosnmp.AES, PrivPassword: "privpassword"},
+		{Name: "sha256", AuthProtocol: gosnmp.SHA256, AuthPassword: "authpassword"},
+	}
+	for _, u := range users {
+		if err := a.AddUser(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	v3 := func(user User, flags gosnmp.SnmpV3MsgFlags) *gosnmp.GoSNMP {
+		g := newClient(t, a, gosnmp.Version3)
+		g.SecurityModel = gosnmp.UserSecurityModel
+		g.MsgFlags = flags
+		g.SecurityParameters = &gosnmp.UsmSecurityParameters{
+			UserName:                 user.Name,
+			AuthenticationProtocol:   user.AuthProtocol,
+			AuthenticationPassphrase: user.AuthPassword,
+			PrivacyProtocol:          user.PrivProtocol,
+			PrivacyPassphrase:        user.PrivPassword,
+		}
+		connect(t, g)
+		return g
+	}
+
+	levels := []gosnmp.SnmpV3MsgFlags{gosnmp.NoAuthNoPriv, gosnmp.AuthPriv, gosnmp.AuthPriv, gosnmp.AuthNoPriv}
+	for i, u := range users {
+		g := v3(u, levels[i])
+		pdus, err := g.BulkWalkAll("1.3.6.1.2.1.1")
+		if err != nil {
+			t.Fatalf("%s: %s", u.Name, err)
+		}
+		if len(pdus) != 4 {
+			t.Errorf("%s: want 4 PDUs, got %d", u.Name, len(pdus))
+		}
+	}
+
+	wrong := users[2]
+	wrong.AuthPassword = "wrongpassword"
+	if _, err := v3(wrong, gosnmp.AuthPriv).Get([]string{"1.3.6.1.2.1.1.5.0"}); !errors.Is(err, gosnmp.ErrWrongDigest) {
+		t.Errorf("want wrong digest error, got %v", err)
+	}
+	unknown := users[3]
+	unknown.Name = "nobody"
+	if _, err := v3(unknown, gosnmp.AuthNoPriv).Get([]string{"1.3.6.1.2.1.1.5.0"}); !errors.Is(err, gosnmp.ErrUnknownUsername) {
+		t.Errorf("want unknown user error, got %v", err)
+	}
+	if _, err := v3(users[0], gosnmp.NoAuthNoPriv).Get([]string{"1.3.6.1.2.1.1.5.0"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := v3(User{Name: "sha256"}, gosnmp.NoAuthNoPriv).Get([]string{"1.3.6.1.2.1.1.5.0"}); !errors.Is(err, gosnmp.ErrUnknownSecurityLevel) {
+		t.Errorf("want unsupported security level error, got %v", err)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	a := startAgent(t)
+	g := newClient(t, a, gosnmp.Version2c)
+	g.Retries = 2
+	connect(t, g)
+
+	a.Drop(2)
+	before := a.Requests()
+	if _, err := g.Get([]string{"1.3.6.1.2.1.1.5.0"}); err != nil {
+		t.Fatalf("expected retries to get through, got %s", err)
+	}
+	if got := a.Requests() - before; got != 3 {
+		t.Errorf("want 3 requests, got %d", got)
+	}
+
+	a.Drop(-1)
+	if _, err := g.Get([]string{"1.3.6.1.2.1.1.5.0"}); err == nil {
+		t.Error("expected timeout while dropping all requests")
+	}
+	a.Drop(0)
+
+	a.SetError("1.3.6.1.2.1.2.2.1.10", gosnmp.GenErr)
+	res, err := g.GetBulk([]string{"1.3.6.1.2.1.2.2.1.8"}, 0, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Error != gosnmp.GenErr || res.ErrorIndex != 1 {
+		t.Errorf("want genErr at 1, got %s at %d", res.Error, res.ErrorIndex)
+	}
+	a.SetError("1.3.6.1.2.1.2.2.1.10", gosnmp.NoError)
+
+	a.SetMaxVarbinds(3)
+	res, err = g.GetBulk([]string{"1.3.6.1.2.1.2.2.1.8"}, 0, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Error != gosnmp.TooBig {
+		t.Errorf("want tooBig, got %s", res.Error)
+	}
+	a.SetMaxVarbinds(0)
+
+	// Late responses to earlier attempts are accepted, so don't retry.
+	g.Retries = 0
+	a.SetLatency(300 * time.Millisecond)
+	if _, err := g.Get([]string{"1.3.6.1.2.1.1.5.0"}); err == nil {
+		t.Error("expected timeout with latency over the timeout")
+	}
+}
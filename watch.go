@@ -0,0 +1,97 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/shatteredsilicon/snmp_exporter/config"
+)
+
+// watchedPaths returns the paths of the files a reload reads: the
+// configuration files, the SSM mapping file, if any, and the secret files of
+// the auths of the current configuration.
+func watchedPaths(configFiles []string, mappingFile string, conf *config.Config) []string {
+	paths := append([]string{}, configFiles...)
+	if mappingFile != "" {
+		paths = append(paths, mappingFile)
+	}
+	if conf != nil {
+		paths = append(paths, conf.SecretFiles()...)
+	}
+	return paths
+}
+
+// configFilesState returns a hash of the names and contents of the files
+// matching the paths, which changes when any of them is changed, added or
+// removed.
+func configFilesState(paths []string) string {
+	hash := sha256.New()
+	for _, p := range paths {
+		files, err := filepath.Glob(p)
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			hash.Write([]byte(f))
+			content, err := os.ReadFile(f)
+			if err != nil {
+				// Retry once the file is readable.
+				hash.Write([]byte(err.Error()))
+				continue
+			}
+			hash.Write(content)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// watchConfig checks the files returned by paths for changes every interval
+// and calls reload once they have been unchanged for debounce, so that a
+// configuration written in several steps is loaded once. The paths are
+// looked up again after each reload, which can change them.
+func watchConfig(ctx context.Context, paths func() []string, interval, debounce time.Duration, logger log.Logger, reload func()) {
+	state := configFilesState(paths())
+	// When the files last changed, zero if there's no change to load.
+	var changed time.Time
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if s := configFilesState(paths()); s != state {
+				state = s
+				changed = now
+				level.Debug(logger).Log("msg", "Configuration files changed")
+				continue
+			}
+			if !changed.IsZero() && now.Sub(changed) >= debounce {
+				changed = time.Time{}
+				level.Info(logger).Log("msg", "Reloading changed configuration files")
+				reload()
+				state = configFilesState(paths())
+			}
+		}
+	}
+}